@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ashish0kumar/tint/recolor"
+)
+
+// animation is an input with several frames that is recolored frame by frame
+type animation struct {
+	frames  int
+	recolor func(ctx context.Context, opts recolor.Options) (write func(w io.Writer) error, err error)
+}
+
+// decodeAnimation reads every frame of a GIF or an animated PNG
+// It returns nil for other formats and for PNGs without animation chunks
+func decodeAnimation(data []byte, imagePath string, format recolor.Format) (*animation, error) {
+	if format != recolor.FormatGIF && format != recolor.FormatPNG {
+		return nil, nil
+	}
+
+	switch {
+	case format == recolor.FormatGIF:
+		g, err := recolor.DecodeGIF(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode image '%s': %w", displayPath(imagePath), err)
+		}
+		return &animation{
+			frames: len(g.Image),
+			recolor: func(ctx context.Context, opts recolor.Options) (func(w io.Writer) error, error) {
+				out, err := recolor.RecolorGIF(ctx, g, opts)
+				if err != nil {
+					return nil, err
+				}
+				return func(w io.Writer) error { return recolor.EncodeGIF(w, out) }, nil
+			},
+		}, nil
+
+	case recolor.IsAnimatedPNG(data):
+		a, err := recolor.DecodeAPNG(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode image '%s': %w", displayPath(imagePath), err)
+		}
+		return &animation{
+			frames: len(a.Frames),
+			recolor: func(ctx context.Context, opts recolor.Options) (func(w io.Writer) error, error) {
+				out, err := recolor.RecolorAPNG(ctx, a, opts)
+				if err != nil {
+					return nil, err
+				}
+				return func(w io.Writer) error { return recolor.EncodeAPNG(w, out) }, nil
+			},
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ashish0kumar/tint/formats/icc"
+	"github.com/ashish0kumar/tint/formats/metadata"
+)
+
+// Modes of --color-profile
+const (
+	profileSRGB   = "srgb"   // Convert to sRGB before mapping and write sRGB
+	profileSource = "source" // Convert to sRGB before mapping, then back to the input profile
+	profileIgnore = "ignore" // Treat the pixel values as sRGB
+)
+
+// parseProfileMode validates a --color-profile value
+func parseProfileMode(s string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(s)); mode {
+	case profileSRGB, profileSource, profileIgnore:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid color profile mode '%s'. Use srgb, source or ignore", s)
+	}
+}
+
+// inputProfile returns the ICC profile embedded in the input
+// It returns nil when there is none, when it is sRGB already, or when it cannot be used.
+func inputProfile(meta *metadata.Metadata) *icc.Profile {
+	if meta == nil || len(meta.ICC) == 0 {
+		return nil
+	}
+	profile, err := icc.Parse(meta.ICC)
+	if err != nil {
+		log.Printf("Color profile ignored: %v", err)
+		return nil
+	}
+	if profile.IsSRGB() {
+		return nil
+	}
+	return profile
+}
@@ -0,0 +1,361 @@
+// Package apng reads and writes animated PNG (APNG) images
+//
+// Frames are decoded with image/png, so every color type, bit depth and
+// interlacing method supported there works for APNG frames as well. Frames
+// are always written as 8-bit RGBA.
+package apng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+// Dispose operations, applied to a frame's region after it has been shown
+const (
+	DisposeOpNone       = 0 // Leave the frame as it is
+	DisposeOpBackground = 1 // Clear the region to transparent black
+	DisposeOpPrevious   = 2 // Restore the region to what it was before the frame
+)
+
+// Blend operations, used to draw a frame onto the canvas
+const (
+	BlendOpSource = 0 // Replace the region, including alpha
+	BlendOpOver   = 1 // Alpha-composite the frame over the region
+)
+
+// Frame is one frame of an animation together with its control data
+type Frame struct {
+	Image     image.Image // Frame pixels, with bounds starting at (0, 0)
+	XOffset   int         // Position of the frame on the canvas
+	YOffset   int
+	DelayNum  uint16 // Delay before the next frame is DelayNum/DelayDen seconds
+	DelayDen  uint16 // A denominator of 0 means 100
+	DisposeOp uint8
+	BlendOp   uint8
+}
+
+// Delay returns how long the frame is shown
+func (f Frame) Delay() time.Duration {
+	den := f.DelayDen
+	if den == 0 {
+		den = 100
+	}
+	return time.Duration(f.DelayNum) * time.Second / time.Duration(den)
+}
+
+// APNG is an animated PNG
+type APNG struct {
+	Width, Height int
+	NumPlays      uint32      // Number of times to play the animation, 0 means forever
+	Default       image.Image // Image shown by viewers without APNG support, nil if it is Frames[0]
+	Frames        []Frame
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ErrNotAnimated is returned by Decode for a PNG without an acTL chunk
+var ErrNotAnimated = errors.New("apng: not an animated png")
+
+// chunk is a raw PNG chunk
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+// readChunks splits a PNG file into its chunks, verifying the signature and checksums
+func readChunks(data []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("apng: missing png signature")
+	}
+	data = data[len(pngSignature):]
+
+	var chunks []chunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		length := binary.BigEndian.Uint32(data[:4])
+		if uint64(length) > uint64(len(data)-12) {
+			return nil, errors.New("apng: chunk length exceeds the file")
+		}
+		typ := string(data[4:8])
+		body := data[8 : 8+length]
+		crc := binary.BigEndian.Uint32(data[8+length : 12+length])
+		if crc32.ChecksumIEEE(data[4:8+length]) != crc {
+			return nil, fmt.Errorf("apng: bad checksum in %s chunk", typ)
+		}
+		chunks = append(chunks, chunk{typ: typ, data: body})
+		data = data[12+length:]
+		if typ == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// IsAnimated reports whether data is a PNG with an acTL chunk before its image data
+func IsAnimated(data []byte) bool {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return false
+	}
+	data = data[len(pngSignature):]
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data[:4])
+		switch string(data[4:8]) {
+		case "acTL":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		if uint64(length) > uint64(len(data)-12) {
+			return false
+		}
+		data = data[12+length:]
+	}
+	return false
+}
+
+// frameControl holds a decoded fcTL chunk
+type frameControl struct {
+	width, height      uint32
+	xOffset, yOffset   uint32
+	delayNum, delayDen uint16
+	disposeOp, blendOp uint8
+}
+
+func parseFrameControl(data []byte) (frameControl, error) {
+	if len(data) != 26 {
+		return frameControl{}, errors.New("apng: fcTL chunk has the wrong length")
+	}
+	fc := frameControl{
+		width:     binary.BigEndian.Uint32(data[4:8]),
+		height:    binary.BigEndian.Uint32(data[8:12]),
+		xOffset:   binary.BigEndian.Uint32(data[12:16]),
+		yOffset:   binary.BigEndian.Uint32(data[16:20]),
+		delayNum:  binary.BigEndian.Uint16(data[20:22]),
+		delayDen:  binary.BigEndian.Uint16(data[22:24]),
+		disposeOp: data[24],
+		blendOp:   data[25],
+	}
+	if fc.disposeOp > DisposeOpPrevious {
+		return frameControl{}, fmt.Errorf("apng: invalid dispose op %d", fc.disposeOp)
+	}
+	if fc.blendOp > BlendOpOver {
+		return frameControl{}, fmt.Errorf("apng: invalid blend op %d", fc.blendOp)
+	}
+	return fc, nil
+}
+
+// Config describes an animated PNG without decoding its frames
+type Config struct {
+	Width, Height int
+	NumFrames     int
+	NumPlays      uint32
+	FramePixels   int // Sum of the pixels of all frames and the hidden default image
+}
+
+// DecodeConfig reads the canvas size and frame layout of an animated PNG
+// It lets callers reject oversized animations before any frame is decoded
+func DecodeConfig(r io.Reader) (Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Config{}, err
+	}
+	chunks, err := readChunks(data)
+	if err != nil {
+		return Config{}, err
+	}
+	a, err := parse(chunks, false)
+	if err != nil {
+		return Config{}, err
+	}
+	return a.config, nil
+}
+
+// Decode reads every frame of an animated PNG
+// It returns ErrNotAnimated for a plain PNG, which png.Decode handles
+func Decode(r io.Reader) (*APNG, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	a, err := parse(chunks, true)
+	if err != nil {
+		return nil, err
+	}
+	return a.apng, nil
+}
+
+// parsed is the result of parse
+type parsed struct {
+	config Config
+	apng   *APNG
+}
+
+// parse walks the chunks of an animated PNG, decoding frames when decodeFrames is set
+func parse(chunks []chunk, decodeFrames bool) (parsed, error) {
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" || len(chunks[0].data) != 13 {
+		return parsed{}, errors.New("apng: missing IHDR chunk")
+	}
+	ihdr := chunks[0].data
+	width := binary.BigEndian.Uint32(ihdr[0:4])
+	height := binary.BigEndian.Uint32(ihdr[4:8])
+
+	// Chunks that every frame needs in order to be decoded on its own
+	var shared []chunk
+	var actl []byte
+	var controls []frameControl
+	var frameData [][]byte
+	var defaultData []byte
+	defaultIsFrame := false
+	seenIDAT := false
+	current := -1 // Index of the frame whose data is being collected
+
+	for _, c := range chunks[1:] {
+		switch c.typ {
+		case "acTL":
+			if len(c.data) != 8 {
+				return parsed{}, errors.New("apng: acTL chunk has the wrong length")
+			}
+			actl = c.data
+		case "PLTE", "tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT":
+			if !seenIDAT {
+				shared = append(shared, c)
+			}
+		case "fcTL":
+			fc, err := parseFrameControl(c.data)
+			if err != nil {
+				return parsed{}, err
+			}
+			if fc.width == 0 || fc.height == 0 ||
+				uint64(fc.xOffset)+uint64(fc.width) > uint64(width) ||
+				uint64(fc.yOffset)+uint64(fc.height) > uint64(height) {
+				return parsed{}, fmt.Errorf("apng: frame %d does not fit on the %dx%d canvas", len(controls)+1, width, height)
+			}
+			controls = append(controls, fc)
+			frameData = append(frameData, nil)
+			current = len(controls) - 1
+			if !seenIDAT {
+				defaultIsFrame = true
+			}
+		case "IDAT":
+			seenIDAT = true
+			defaultData = append(defaultData, c.data...)
+			if defaultIsFrame && current == 0 {
+				frameData[0] = append(frameData[0], c.data...)
+			}
+		case "fdAT":
+			if len(c.data) < 4 || current < 0 {
+				return parsed{}, errors.New("apng: fdAT chunk without a frame")
+			}
+			frameData[current] = append(frameData[current], c.data[4:]...)
+		}
+	}
+
+	if actl == nil {
+		return parsed{}, ErrNotAnimated
+	}
+	numFrames := binary.BigEndian.Uint32(actl[0:4])
+	if numFrames == 0 || int(numFrames) != len(controls) {
+		return parsed{}, fmt.Errorf("apng: acTL declares %d frames but %d were found", numFrames, len(controls))
+	}
+	if defaultIsFrame && (controls[0].xOffset != 0 || controls[0].yOffset != 0 ||
+		controls[0].width != width || controls[0].height != height) {
+		return parsed{}, errors.New("apng: the first frame must cover the canvas when it is the default image")
+	}
+
+	config := Config{
+		Width:     int(width),
+		Height:    int(height),
+		NumFrames: len(controls),
+		NumPlays:  binary.BigEndian.Uint32(actl[4:8]),
+	}
+	for _, fc := range controls {
+		config.FramePixels += int(fc.width) * int(fc.height)
+	}
+	if !defaultIsFrame {
+		config.FramePixels += int(width) * int(height)
+	}
+	if !decodeFrames {
+		return parsed{config: config}, nil
+	}
+
+	a := &APNG{Width: config.Width, Height: config.Height, NumPlays: config.NumPlays}
+	if !defaultIsFrame {
+		img, err := decodeFrame(ihdr, width, height, shared, defaultData)
+		if err != nil {
+			return parsed{}, fmt.Errorf("apng: default image: %w", err)
+		}
+		a.Default = img
+	}
+	for i, fc := range controls {
+		img, err := decodeFrame(ihdr, fc.width, fc.height, shared, frameData[i])
+		if err != nil {
+			return parsed{}, fmt.Errorf("apng: frame %d: %w", i+1, err)
+		}
+		a.Frames = append(a.Frames, Frame{
+			Image:     img,
+			XOffset:   int(fc.xOffset),
+			YOffset:   int(fc.yOffset),
+			DelayNum:  fc.delayNum,
+			DelayDen:  fc.delayDen,
+			DisposeOp: fc.disposeOp,
+			BlendOp:   fc.blendOp,
+		})
+	}
+	return parsed{config: config, apng: a}, nil
+}
+
+// decodeFrame decodes frame data by wrapping it in a standalone PNG with the frame's size
+func decodeFrame(ihdr []byte, width, height uint32, shared []chunk, data []byte) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no image data")
+	}
+
+	header := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(header[0:4], width)
+	binary.BigEndian.PutUint32(header[4:8], height)
+
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	writeChunk(&buf, "IHDR", header)
+	for _, c := range shared {
+		writeChunk(&buf, c.typ, c.data)
+	}
+	writeChunk(&buf, "IDAT", data)
+	writeChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
+
+// writeChunk writes a chunk with its length and checksum
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(footer[:])
+	return err
+}
@@ -0,0 +1,86 @@
+package apng
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a width x height image with varying colors and alpha
+func testImage(width, height int, seed uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*31) + seed
+	}
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := &APNG{
+		Width:    9,
+		Height:   7,
+		NumPlays: 3,
+		Frames: []Frame{
+			{Image: testImage(9, 7, 0), DelayNum: 1, DelayDen: 10},
+			{Image: testImage(4, 3, 50), XOffset: 5, YOffset: 4, DelayNum: 7, DisposeOp: DisposeOpBackground, BlendOp: BlendOpOver},
+		},
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Width != 9 || out.Height != 7 || out.NumPlays != 3 || len(out.Frames) != 2 {
+		t.Fatalf("decoded %dx%d, %d plays, %d frames", out.Width, out.Height, out.NumPlays, len(out.Frames))
+	}
+	for i, f := range out.Frames {
+		want := in.Frames[i]
+		if !bytes.Equal(f.Image.(*image.NRGBA).Pix, want.Image.(*image.NRGBA).Pix) {
+			t.Errorf("frame %d pixels differ", i)
+		}
+		f.Image, want.Image = nil, nil
+		if f != want {
+			t.Errorf("frame %d = %+v, want %+v", i, f, want)
+		}
+	}
+
+	// Viewers without APNG support show the first frame
+	if still, err := png.Decode(bytes.NewReader(b.Bytes())); err != nil || still.Bounds() != image.Rect(0, 0, 9, 7) {
+		t.Errorf("png.Decode: %v", err)
+	}
+}
+
+func TestSingleFrameIsStill(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, &APNG{Width: 4, Height: 4, Frames: []Frame{{Image: testImage(4, 4, 1)}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(bytes.NewReader(b.Bytes())); !errors.Is(err, ErrNotAnimated) {
+		t.Errorf("Decode error = %v, want ErrNotAnimated", err)
+	}
+}
+
+func TestDefaultImage(t *testing.T) {
+	in := &APNG{
+		Width:   6,
+		Height:  5,
+		Default: testImage(6, 5, 200),
+		Frames:  []Frame{{Image: testImage(3, 3, 10), XOffset: 2, YOffset: 1}},
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(bytes.NewReader(b.Bytes()))
+	if err != nil || out.Default == nil || len(out.Frames) != 1 {
+		t.Fatalf("Decode = %+v, %v, want a default image and one frame", out, err)
+	}
+	if !bytes.Equal(out.Default.(*image.NRGBA).Pix, in.Default.(*image.NRGBA).Pix) {
+		t.Error("default image pixels differ")
+	}
+}
@@ -0,0 +1,194 @@
+package apng
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// Encode writes a to w as an animated PNG
+// A single frame without a separate default image is written as a still PNG.
+func Encode(w io.Writer, a *APNG) error {
+	if len(a.Frames) == 0 {
+		return errors.New("apng: no frames to encode")
+	}
+	if a.Width <= 0 || a.Height <= 0 {
+		return fmt.Errorf("apng: invalid canvas size %dx%d", a.Width, a.Height)
+	}
+	for i, f := range a.Frames {
+		b := f.Image.Bounds()
+		if f.XOffset < 0 || f.YOffset < 0 || f.XOffset+b.Dx() > a.Width || f.YOffset+b.Dy() > a.Height {
+			return fmt.Errorf("apng: frame %d does not fit on the %dx%d canvas", i+1, a.Width, a.Height)
+		}
+	}
+	if a.Default == nil {
+		first := a.Frames[0]
+		b := first.Image.Bounds()
+		if first.XOffset != 0 || first.YOffset != 0 || b.Dx() != a.Width || b.Dy() != a.Height {
+			return errors.New("apng: the first frame must cover the canvas when there is no default image")
+		}
+		if len(a.Frames) == 1 {
+			return png.Encode(w, first.Image)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(a.Width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(a.Height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 6 // Color type: RGBA
+	writeChunk(bw, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:4], uint32(len(a.Frames)))
+	binary.BigEndian.PutUint32(actl[4:8], a.NumPlays)
+	writeChunk(bw, "acTL", actl)
+
+	var seq uint32
+	if a.Default != nil {
+		data, err := compressImage(a.Default)
+		if err != nil {
+			return err
+		}
+		writeChunk(bw, "IDAT", data)
+	}
+
+	for i, f := range a.Frames {
+		b := f.Image.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:16], uint32(f.XOffset))
+		binary.BigEndian.PutUint32(fctl[16:20], uint32(f.YOffset))
+		binary.BigEndian.PutUint16(fctl[20:22], f.DelayNum)
+		binary.BigEndian.PutUint16(fctl[22:24], f.DelayDen)
+		fctl[24] = f.DisposeOp
+		fctl[25] = f.BlendOp
+		writeChunk(bw, "fcTL", fctl)
+		seq++
+
+		data, err := compressImage(f.Image)
+		if err != nil {
+			return err
+		}
+		if i == 0 && a.Default == nil {
+			writeChunk(bw, "IDAT", data)
+			continue
+		}
+		fdat := make([]byte, 4+len(data))
+		binary.BigEndian.PutUint32(fdat[0:4], seq)
+		copy(fdat[4:], data)
+		writeChunk(bw, "fdAT", fdat)
+		seq++
+	}
+
+	writeChunk(bw, "IEND", nil)
+	return bw.Flush()
+}
+
+// compressImage filters and compresses img as 8-bit non-premultiplied RGBA scanlines
+func compressImage(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(b)
+		draw.Draw(nrgba, b, img, b.Min, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	rowLen := 4 * b.Dx()
+	prev := make([]byte, rowLen)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+rowLen)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		offset := nrgba.PixOffset(b.Min.X, y)
+		row := nrgba.Pix[offset : offset+rowLen]
+		best := filterRow(row, prev, filtered)
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		copy(prev, row)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// filterRow applies each PNG filter to row and returns the one with the smallest
+// sum of absolute values, the heuristic recommended by the PNG specification
+func filterRow(row, prev []byte, filtered [][]byte) int {
+	const bpp = 4
+	best, bestSum := 0, -1
+	for ft := 0; ft < 5; ft++ {
+		out := filtered[ft][1:]
+		sum := 0
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+
+			var v byte
+			switch ft {
+			case 0:
+				v = row[i]
+			case 1:
+				v = row[i] - left
+			case 2:
+				v = row[i] - up
+			case 3:
+				v = row[i] - byte((int(left)+int(up))/2)
+			case 4:
+				v = row[i] - paeth(left, up, upLeft)
+			}
+			out[i] = v
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = ft, sum
+		}
+	}
+	return best
+}
+
+// paeth is the Paeth predictor from the PNG specification
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
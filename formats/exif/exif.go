@@ -0,0 +1,116 @@
+// Package exif reads the parts of EXIF metadata that tint acts on
+//
+// EXIF data is a TIFF structure. In JPEG files it is stored in an APP1 segment
+// after the "Exif\x00\x00" identifier. Only the tags of the first IFD are read.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// TagOrientation is the tag of the orientation field in IFD0
+const TagOrientation = 0x0112
+
+// header identifies an APP1 segment holding EXIF data
+var header = []byte("Exif\x00\x00")
+
+// FromJPEG returns the EXIF data of a JPEG file, or nil if it has none
+// The returned slice starts with the TIFF header and shares memory with data.
+func FromJPEG(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil
+	}
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xff {
+			return nil
+		}
+		marker := data[p+1]
+		switch {
+		case marker == 0xff: // Fill byte
+			p++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7): // No payload
+			p += 2
+			continue
+		case marker == 0xda || marker == 0xd9: // Start of scan or end of image
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if length < 2 || p+2+length > len(data) {
+			return nil
+		}
+		payload := data[p+4 : p+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(payload, header) {
+			return payload[len(header):]
+		}
+		p += 2 + length
+	}
+	return nil
+}
+
+// Orientation returns the orientation tag of EXIF data, from 1 to 8
+// It returns 1, meaning no transformation, when the tag is missing or invalid.
+func Orientation(exif []byte) int {
+	order, offset, ok := findTag(exif, TagOrientation)
+	if !ok || offset+2 > len(exif) {
+		return 1
+	}
+	o := int(order.Uint16(exif[offset:]))
+	if o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// findTag returns the byte order of exif and the offset of the value of a
+// SHORT tag in IFD0, which is stored inline in its directory entry
+func findTag(exif []byte, tag uint16) (binary.ByteOrder, int, bool) {
+	if len(exif) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	if order.Uint16(exif[2:]) != 42 {
+		return nil, 0, false
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return nil, 0, false
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(exif) {
+			return nil, 0, false
+		}
+		if order.Uint16(exif[entry:]) != tag {
+			continue
+		}
+		// The value must be one SHORT (type 3)
+		if order.Uint16(exif[entry+2:]) != 3 || order.Uint32(exif[entry+4:]) != 1 {
+			return nil, 0, false
+		}
+		return order, entry + 8, true
+	}
+	return nil, 0, false
+}
+
+// SetOrientation changes the orientation tag of exif in place
+// It reports whether the tag was found; exif is left unchanged if not.
+func SetOrientation(exif []byte, orientation int) bool {
+	order, offset, ok := findTag(exif, TagOrientation)
+	if !ok || offset+2 > len(exif) {
+		return false
+	}
+	order.PutUint16(exif[offset:], uint16(orientation))
+	return true
+}
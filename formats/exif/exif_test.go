@@ -0,0 +1,42 @@
+package exif
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// jpegWithOrientation returns the start of a JPEG file whose EXIF data has one orientation tag
+func jpegWithOrientation(orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, TagOrientation)
+	tiff = append(tiff, 0, 3, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	data := []byte{0xff, 0xd8, 0xff, 0xe1}
+	data = binary.BigEndian.AppendUint16(data, uint16(2+len(header)+len(tiff)))
+	data = append(append(data, header...), tiff...)
+	return append(data, 0xff, 0xda)
+}
+
+func TestOrientation(t *testing.T) {
+	for value, want := range []int{1, 1, 2, 3, 4, 5, 6, 7, 8, 1} {
+		exif := FromJPEG(jpegWithOrientation(uint16(value)))
+		if got := Orientation(exif); got != want {
+			t.Errorf("Orientation of tag value %d = %d, want %d", value, got, want)
+		}
+	}
+	if got := Orientation(nil); got != 1 {
+		t.Errorf("Orientation without EXIF data = %d, want 1", got)
+	}
+}
+
+func TestSetOrientation(t *testing.T) {
+	exif := FromJPEG(jpegWithOrientation(6))
+	if !SetOrientation(exif, 1) || Orientation(exif) != 1 {
+		t.Errorf("SetOrientation(1) left orientation %d", Orientation(exif))
+	}
+	if SetOrientation([]byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00"), 1) {
+		t.Error("SetOrientation reported a tag in EXIF data without one")
+	}
+}
@@ -0,0 +1,131 @@
+package icc
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// lutSize is the number of entries in the tables that encode linear values
+// 65536 keeps the rounding error of the steep dark end of the curves well below one 8-bit step
+const lutSize = 1 << 16
+
+// Transform converts 8-bit pixels between two RGB color spaces
+type Transform struct {
+	decode [3][256]float64    // Encoded source values to linear
+	matrix [3][3]float64      // Linear source RGB to linear destination RGB
+	encode [3]*[lutSize]uint8 // Linear destination values to encoded
+}
+
+// ToSRGB returns the transform from the profile's color space to sRGB
+func (p *Profile) ToSRGB() *Transform {
+	t := &Transform{matrix: mul(inverse(srgbToXYZ), p.toXYZ)}
+	for c := range t.decode {
+		for v := range t.decode[c] {
+			t.decode[c][v] = p.curves[c](float64(v) / 255)
+		}
+	}
+	enc := encodeTable(srgbToLinear)
+	t.encode = [3]*[lutSize]uint8{enc, enc, enc}
+	return t
+}
+
+// FromSRGB returns the transform from sRGB to the profile's color space
+func (p *Profile) FromSRGB() *Transform {
+	t := &Transform{matrix: mul(inverse(p.toXYZ), srgbToXYZ)}
+	for c := range t.decode {
+		for v := range t.decode[c] {
+			t.decode[c][v] = srgbToLinear(float64(v) / 255)
+		}
+		t.encode[c] = encodeTable(p.curves[c])
+	}
+	return t
+}
+
+// Apply converts img, keeping alpha, and returns the result as non-premultiplied RGBA
+func (t *Transform) Apply(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := dst.PixOffset(0, y-b.Min.Y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, bl := t.decode[0][c.R], t.decode[1][c.G], t.decode[2][c.B]
+			dst.Pix[i+0] = t.encode[0][lutIndex(t.matrix[0][0]*r+t.matrix[0][1]*g+t.matrix[0][2]*bl)]
+			dst.Pix[i+1] = t.encode[1][lutIndex(t.matrix[1][0]*r+t.matrix[1][1]*g+t.matrix[1][2]*bl)]
+			dst.Pix[i+2] = t.encode[2][lutIndex(t.matrix[2][0]*r+t.matrix[2][1]*g+t.matrix[2][2]*bl)]
+			dst.Pix[i+3] = c.A
+			i += 4
+		}
+	}
+	return dst
+}
+
+// lutIndex clips a linear value to [0, 1] and returns its position in an encode table
+func lutIndex(v float64) int {
+	return int(clamp01(v)*(lutSize-1) + 0.5)
+}
+
+// encodeTable inverts a curve from encoded to linear values into a table from
+// linear values to the nearest 8-bit encoded value
+// Code k is used up to the linear value of the midpoint between k and k+1,
+// which needs one evaluation of the curve per code.
+func encodeTable(curve func(float64) float64) *[lutSize]uint8 {
+	table := new([lutSize]uint8)
+	k := 0
+	threshold := curve(0.5 / 255)
+	for i := range table {
+		v := float64(i) / (lutSize - 1)
+		for k < 255 && v >= threshold {
+			k++
+			threshold = curve((float64(k) + 0.5) / 255)
+		}
+		table[i] = uint8(k)
+	}
+	return table
+}
+
+// srgbToLinear decodes an sRGB value
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func clamp01(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
+
+func mul(a, b [3][3]float64) [3][3]float64 {
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func det(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// inverse returns the inverse of m, which Parse has checked to be invertible
+func inverse(m [3][3]float64) [3][3]float64 {
+	d := det(m)
+	var out [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// Cofactor of m[j][i], divided by the determinant
+			r0, r1 := (j+1)%3, (j+2)%3
+			c0, c1 := (i+1)%3, (i+2)%3
+			out[i][j] = (m[r0][c0]*m[r1][c1] - m[r0][c1]*m[r1][c0]) / d
+		}
+	}
+	return out
+}
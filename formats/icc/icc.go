@@ -0,0 +1,221 @@
+// Package icc reads RGB matrix/TRC ICC profiles and converts images between them and sRGB
+//
+// Matrix/TRC profiles describe each channel with a tone reproduction curve and
+// map the linear values to the D50 profile connection space with a 3x3 matrix.
+// This covers Display P3, Adobe RGB, ProPhoto RGB and most camera and display
+// profiles. Profiles built from lookup tables are rejected with ErrUnsupported.
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// ErrUnsupported is returned for profiles that are not RGB matrix/TRC profiles
+var ErrUnsupported = errors.New("icc: unsupported profile")
+
+const headerSize = 128
+
+// Profile is a parsed RGB matrix/TRC profile
+type Profile struct {
+	description string
+	toXYZ       [3][3]float64            // Linear RGB to D50 XYZ, one column per channel
+	curves      [3]func(float64) float64 // Tone reproduction curves, encoded to linear
+}
+
+// srgbToXYZ maps linear sRGB to D50 XYZ, with the Bradford adaptation used by ICC sRGB profiles
+var srgbToXYZ = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// Parse reads an ICC profile
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < headerSize+4 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("icc: invalid profile header")
+	}
+	if cs := string(data[16:20]); cs != "RGB " {
+		return nil, fmt.Errorf("%w: color space '%s'", ErrUnsupported, strings.TrimSpace(cs))
+	}
+	if pcs := string(data[20:24]); pcs != "XYZ " {
+		return nil, fmt.Errorf("%w: connection space '%s'", ErrUnsupported, strings.TrimSpace(pcs))
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[headerSize:]))
+	for i := 0; i < count; i++ {
+		entry := headerSize + 4 + 12*i
+		if entry+12 > len(data) {
+			return nil, errors.New("icc: truncated tag table")
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 8 || offset+size > len(data) || offset+size < offset {
+			return nil, errors.New("icc: tag outside of profile")
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	p := &Profile{description: parseDescription(tags["desc"])}
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag, ok := tags[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: no '%s' tag, only matrix/TRC profiles are supported", ErrUnsupported, name)
+		}
+		if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+			return nil, fmt.Errorf("icc: invalid '%s' tag", name)
+		}
+		for row := 0; row < 3; row++ {
+			p.toXYZ[row][i] = s15Fixed16(tag[8+4*row:])
+		}
+	}
+	for i, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		tag, ok := tags[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: no '%s' tag, only matrix/TRC profiles are supported", ErrUnsupported, name)
+		}
+		curve, err := parseCurve(tag)
+		if err != nil {
+			return nil, fmt.Errorf("icc: invalid '%s' tag: %w", name, err)
+		}
+		p.curves[i] = curve
+	}
+	if det(p.toXYZ) == 0 {
+		return nil, errors.New("icc: colorant matrix is not invertible")
+	}
+	return p, nil
+}
+
+// Description returns the name of the profile, such as "Display P3"
+func (p *Profile) Description() string {
+	return p.description
+}
+
+// IsSRGB reports whether the profile is close enough to sRGB that converting would change nothing
+func (p *Profile) IsSRGB() bool {
+	for row := range srgbToXYZ {
+		for col := range srgbToXYZ[row] {
+			if math.Abs(p.toXYZ[row][col]-srgbToXYZ[row][col]) > 0.002 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for i := 0; i <= 32; i++ {
+			x := float64(i) / 32
+			if math.Abs(curve(x)-srgbToLinear(x)) > 0.002 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// s15Fixed16 decodes a signed 15.16 fixed point number
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseCurve reads a curv or para tag as a function from encoded to linear values
+func parseCurve(tag []byte) (func(float64) float64, error) {
+	switch string(tag[:4]) {
+	case "curv":
+		if len(tag) < 12 {
+			return nil, errors.New("truncated curve")
+		}
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n < 0 || len(tag) < 12+2*n {
+			return nil, errors.New("truncated curve")
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := clamp01(x) * float64(n-1)
+			i := min(int(pos), n-2)
+			frac := pos - float64(i)
+			return table[i]*(1-frac) + table[i+1]*frac
+		}, nil
+
+	case "para":
+		if len(tag) < 12 {
+			return nil, errors.New("truncated parametric curve")
+		}
+		kind := binary.BigEndian.Uint16(tag[8:])
+		counts := []int{1, 3, 4, 5, 7}
+		if int(kind) >= len(counts) {
+			return nil, fmt.Errorf("unknown parametric curve type %d", kind)
+		}
+		if len(tag) < 12+4*counts[kind] {
+			return nil, errors.New("truncated parametric curve")
+		}
+		// Missing parameters keep their identity values, so every type is a case of type 4
+		g, a, b, c, d, e, f := 1.0, 1.0, 0.0, 0.0, 0.0, 0.0, 0.0
+		params := []*float64{&g, &a, &b, &c, &d, &e, &f}
+		for i := 0; i < counts[kind]; i++ {
+			*params[i] = s15Fixed16(tag[12+4*i:])
+		}
+		// Types 1 and 2 place the start of the power segment at -b/a
+		if (kind == 1 || kind == 2) && a == 0 {
+			return nil, fmt.Errorf("parametric curve type %d with a = 0", kind)
+		}
+		switch kind {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(math.Max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type '%s'", strings.TrimSpace(string(tag[:4])))
+}
+
+// parseDescription reads a v2 desc or v4 mluc description tag, returning "" if it cannot
+func parseDescription(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if n <= 0 || 12+n > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		if len(tag) < 28 {
+			return ""
+		}
+		// Use the first record, descriptions rarely differ between languages
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if length < 0 || offset < 0 || offset+length > len(tag) {
+			return ""
+		}
+		units := make([]uint16, length/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
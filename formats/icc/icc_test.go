@@ -0,0 +1,122 @@
+package icc
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+// fixed appends v as a signed 15.16 fixed point number
+func fixed(data []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint32(data, uint32(int32(math.Round(v*65536))))
+}
+
+// paraTag returns a parametric curve tag
+func paraTag(kind uint16, params ...float64) []byte {
+	tag := binary.BigEndian.AppendUint16([]byte("para\x00\x00\x00\x00"), kind)
+	tag = append(tag, 0, 0)
+	for _, p := range params {
+		tag = fixed(tag, p)
+	}
+	return tag
+}
+
+// srgbCurve is the sRGB tone curve as a type 3 parametric curve
+var srgbCurve = paraTag(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+// buildProfile returns a matrix/TRC profile with the given colorants, one column per
+// channel, and the same curve for every channel
+func buildProfile(colorants [3][3]float64, trc []byte) []byte {
+	tags := map[string][]byte{"rTRC": trc, "gTRC": trc, "bTRC": trc}
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for row := 0; row < 3; row++ {
+			tag = fixed(tag, colorants[row][i])
+		}
+		tags[name] = tag
+	}
+
+	data := make([]byte, headerSize)
+	copy(data[16:], "RGB XYZ ")
+	copy(data[36:], "acsp")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(tags)))
+	var body []byte
+	offset := len(data) + 12*len(tags)
+	for name, tag := range tags {
+		data = append(data, name...)
+		data = binary.BigEndian.AppendUint32(data, uint32(offset+len(body)))
+		data = binary.BigEndian.AppendUint32(data, uint32(len(tag)))
+		body = append(body, tag...)
+	}
+	return append(data, body...)
+}
+
+func TestParseCurve(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		x, y float64
+	}{
+		{"identity", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00"), 0.3, 0.3},
+		{"gamma 2", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00"), 0.5, 0.25},
+		{"table", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x80\x00\xff\xff"), 0.75, 0.75},
+		{"type 0", paraTag(0, 2), 0.5, 0.25},
+		{"type 1", paraTag(1, 2, 2, -1), 0.75, 0.25},
+		{"type 2", paraTag(2, 2, 2, -1, 0.1), 0.25, 0.1},
+		{"type 3", srgbCurve, 0.02, 0.02 / 12.92},
+		{"type 4", paraTag(4, 1, 1, 0, 0.5, 0.5, 0.1, 0.2), 0.25, 0.325},
+	}
+	for _, tt := range tests {
+		curve, err := parseCurve(tt.tag)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if y := curve(tt.x); math.Abs(y-tt.y) > 1e-4 {
+			t.Errorf("%s: curve(%g) = %g, want %g", tt.name, tt.x, y, tt.y)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	srgb, err := Parse(buildProfile(srgbToXYZ, srgbCurve))
+	if err != nil || !srgb.IsSRGB() {
+		t.Errorf("sRGB profile: IsSRGB = false, %v", err)
+	}
+	gamma, err := Parse(buildProfile(srgbToXYZ, paraTag(0, 2.2)))
+	if err != nil || gamma.IsSRGB() {
+		t.Errorf("gamma 2.2 profile: IsSRGB = true, %v", err)
+	}
+
+	lut := buildProfile(srgbToXYZ, srgbCurve)
+	copy(lut[headerSize+4:], "A2B0") // Rename the first tag, so a required tag is missing
+	if _, err := Parse(lut); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("profile without a required tag: error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestTransformRoundTrip(t *testing.T) {
+	p, err := Parse(buildProfile(srgbToXYZ, paraTag(0, 2.2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	out := p.ToSRGB().Apply(p.FromSRGB().Apply(img))
+	for i, v := range out.Pix {
+		if d := int(v) - int(img.Pix[i]); d < -1 || d > 1 {
+			t.Fatalf("byte %d changed from %d to %d", i, img.Pix[i], v)
+		}
+	}
+}
+
+func TestParseCurveZeroSlope(t *testing.T) {
+	// Types 1 and 2 divide by a to find where the power segment starts
+	for _, tag := range [][]byte{paraTag(1, 2.2, 0, 0), paraTag(2, 2.2, 0, 0.5, 0.1)} {
+		if _, err := parseCurve(tag); err == nil {
+			t.Errorf("parseCurve accepted type %d with a = 0", tag[9])
+		}
+	}
+}
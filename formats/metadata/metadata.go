@@ -0,0 +1,364 @@
+// Package metadata copies EXIF, XMP, ICC and text metadata between JPEG and PNG files
+//
+// Metadata is read into a format-neutral Metadata value, so it can be written
+// to either format: EXIF goes to an APP1 segment or an eXIf chunk, XMP to an
+// APP1 segment or an iTXt chunk, and ICC profiles to APP2 segments or an iCCP
+// chunk. PNG text chunks have no JPEG equivalent and are only written to PNG.
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"regexp"
+
+	"github.com/ashish0kumar/tint/formats/exif"
+)
+
+// Identifiers of JPEG APP segments and PNG chunks that carry metadata
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+	xmpKeyword = "XML:com.adobe.xmp"
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+const (
+	maxSegment     = 65533 // Largest JPEG segment payload
+	defaultICCName = "ICC Profile"
+
+	// Limits on decompressed PNG metadata, so a small zlib bomb cannot exhaust memory
+	MaxICCSize = 4 << 20
+	MaxXMPSize = 4 << 20
+)
+
+// Chunk is a raw PNG chunk
+type Chunk struct {
+	Type string
+	Data []byte
+}
+
+// Metadata holds the metadata of an image file
+type Metadata struct {
+	EXIF    []byte  // EXIF data, starting with the TIFF header
+	XMP     []byte  // XMP packet
+	ICC     []byte  // Uncompressed ICC profile
+	ICCName string  // Profile name from a PNG iCCP chunk
+	Text    []Chunk // PNG tEXt, zTXt and iTXt chunks other than XMP
+}
+
+// IsEmpty reports whether m holds no metadata
+func (m *Metadata) IsEmpty() bool {
+	return m == nil || (len(m.EXIF) == 0 && len(m.XMP) == 0 && len(m.ICC) == 0 && len(m.Text) == 0)
+}
+
+// xmpOrientation matches the tiff:Orientation property of an XMP packet, as an
+// attribute or as an element
+var xmpOrientation = regexp.MustCompile(`(tiff:Orientation\s*=\s*["']|<tiff:Orientation>\s*)[0-9]+`)
+
+// ResetOrientation sets the EXIF and XMP orientation to 1, for images that were turned upright
+// Viewers that read either one would otherwise rotate the image a second time
+func (m *Metadata) ResetOrientation() {
+	if len(m.EXIF) > 0 {
+		e := bytes.Clone(m.EXIF)
+		if exif.SetOrientation(e, 1) {
+			m.EXIF = e
+		}
+	}
+	if len(m.XMP) > 0 {
+		m.XMP = xmpOrientation.ReplaceAll(m.XMP, []byte("${1}1"))
+	}
+}
+
+// FromJPEG reads the EXIF, XMP and ICC segments of a JPEG file
+func FromJPEG(data []byte) (*Metadata, error) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, errors.New("metadata: not a JPEG file")
+	}
+	m := &Metadata{}
+	var iccParts [][]byte
+	for p := 2; p+4 <= len(data); {
+		if data[p] != 0xff {
+			return nil, errors.New("metadata: invalid JPEG marker")
+		}
+		marker := data[p+1]
+		if marker == 0xff {
+			p++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			p += 2
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if length < 2 || p+2+length > len(data) {
+			return nil, errors.New("metadata: truncated JPEG segment")
+		}
+		payload := data[p+4 : p+2+length]
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) && m.EXIF == nil:
+			m.EXIF = bytes.Clone(payload[len(exifHeader):])
+		case marker == 0xe1 && bytes.HasPrefix(payload, xmpHeader) && m.XMP == nil:
+			m.XMP = bytes.Clone(payload[len(xmpHeader):])
+		case marker == 0xe2 && bytes.HasPrefix(payload, iccHeader) && len(payload) > len(iccHeader)+2:
+			// Profiles are split into numbered parts, counting from 1
+			seq, count := int(payload[len(iccHeader)]), int(payload[len(iccHeader)+1])
+			if seq < 1 || seq > count {
+				break
+			}
+			if iccParts == nil {
+				iccParts = make([][]byte, count)
+			}
+			if seq <= len(iccParts) {
+				iccParts[seq-1] = payload[len(iccHeader)+2:]
+			}
+		}
+		p += 2 + length
+	}
+
+	complete := len(iccParts) > 0
+	for _, part := range iccParts {
+		complete = complete && part != nil
+	}
+	if complete {
+		m.ICC = bytes.Join(iccParts, nil)
+	}
+	return m, nil
+}
+
+// FromPNG reads the iCCP, eXIf, tEXt, zTXt and iTXt chunks of a PNG file
+func FromPNG(data []byte) (*Metadata, error) {
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	m := &Metadata{}
+	for _, c := range chunks {
+		switch c.Type {
+		case "eXIf":
+			m.EXIF = bytes.Clone(c.Data)
+		case "iCCP":
+			name, rest, ok := bytes.Cut(c.Data, []byte{0})
+			if !ok || len(rest) < 1 || rest[0] != 0 {
+				return nil, errors.New("metadata: invalid iCCP chunk")
+			}
+			profile, err := inflate(rest[1:], MaxICCSize)
+			if err != nil {
+				return nil, fmt.Errorf("metadata: invalid iCCP chunk: %w", err)
+			}
+			m.ICC, m.ICCName = profile, string(name)
+		case "iTXt":
+			if xmp, ok, err := parseXMP(c.Data); err != nil {
+				return nil, err
+			} else if ok {
+				m.XMP = xmp
+				continue
+			}
+			m.Text = append(m.Text, Chunk{c.Type, bytes.Clone(c.Data)})
+		case "tEXt", "zTXt":
+			m.Text = append(m.Text, Chunk{c.Type, bytes.Clone(c.Data)})
+		}
+	}
+	return m, nil
+}
+
+// parseXMP returns the text of an iTXt chunk holding XMP, and false for other iTXt chunks
+func parseXMP(data []byte) ([]byte, bool, error) {
+	keyword, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || string(keyword) != xmpKeyword || len(rest) < 2 {
+		return nil, false, nil
+	}
+	compressed := rest[0] == 1
+	// Skip the compression flag and method, then the language tag and translated keyword
+	rest = rest[2:]
+	for i := 0; i < 2; i++ {
+		if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+			return nil, false, errors.New("metadata: invalid iTXt chunk")
+		}
+	}
+	if !compressed {
+		return bytes.Clone(rest), true, nil
+	}
+	text, err := inflate(rest, MaxXMPSize)
+	if err != nil {
+		return nil, false, fmt.Errorf("metadata: invalid iTXt chunk: %w", err)
+	}
+	return text, true, nil
+}
+
+// readChunks splits a PNG file into its chunks
+func readChunks(data []byte) ([]Chunk, error) {
+	if !bytes.HasPrefix(data, pngMagic) {
+		return nil, errors.New("metadata: not a PNG file")
+	}
+	var chunks []Chunk
+	for p := len(pngMagic); p < len(data); {
+		if p+8 > len(data) {
+			return nil, errors.New("metadata: truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[p:]))
+		if length < 0 || p+12+length > len(data) {
+			return nil, errors.New("metadata: truncated PNG chunk")
+		}
+		chunks = append(chunks, Chunk{string(data[p+4 : p+8]), data[p+8 : p+8+length]})
+		p += 12 + length
+	}
+	return chunks, nil
+}
+
+// inflate decompresses zlib data, failing when it inflates to more than limit bytes
+func inflate(data []byte, limit int64) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("data inflates to more than %d bytes", limit)
+	}
+	return out, nil
+}
+
+// WriteJPEG writes the JPEG file encoded to w, with the metadata of m inserted after its SOI marker
+// PNG text chunks are not written, JPEG has no place for them.
+func (m *Metadata) WriteJPEG(w io.Writer, encoded []byte) error {
+	if !bytes.HasPrefix(encoded, []byte{0xff, 0xd8}) {
+		return errors.New("metadata: not a JPEG file")
+	}
+
+	var segments bytes.Buffer
+	writeSegment := func(marker byte, parts ...[]byte) error {
+		length := 2
+		for _, part := range parts {
+			length += len(part)
+		}
+		if length > maxSegment+2 {
+			return fmt.Errorf("metadata: %d bytes do not fit in a JPEG segment", length-2)
+		}
+		segments.Write([]byte{0xff, marker, byte(length >> 8), byte(length)})
+		for _, part := range parts {
+			segments.Write(part)
+		}
+		return nil
+	}
+
+	if len(m.EXIF) > 0 {
+		if err := writeSegment(0xe1, exifHeader, m.EXIF); err != nil {
+			return fmt.Errorf("cannot write EXIF: %w", err)
+		}
+	}
+	if len(m.XMP) > 0 {
+		if err := writeSegment(0xe1, xmpHeader, m.XMP); err != nil {
+			return fmt.Errorf("cannot write XMP: %w", err)
+		}
+	}
+	if len(m.ICC) > 0 {
+		partSize := maxSegment - len(iccHeader) - 2
+		count := (len(m.ICC) + partSize - 1) / partSize
+		if count > 255 {
+			return fmt.Errorf("metadata: ICC profile of %d bytes is too large for JPEG", len(m.ICC))
+		}
+		for i := 0; i < count; i++ {
+			part := m.ICC[i*partSize : min((i+1)*partSize, len(m.ICC))]
+			if err := writeSegment(0xe2, iccHeader, []byte{byte(i + 1), byte(count)}, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := w.Write(encoded[:2]); err != nil {
+		return err
+	}
+	if _, err := w.Write(segments.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[2:])
+	return err
+}
+
+// WritePNG writes the PNG file encoded to w, with the metadata of m inserted after its IHDR chunk
+func (m *Metadata) WritePNG(w io.Writer, encoded []byte) error {
+	chunks, err := readChunks(encoded)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 || chunks[0].Type != "IHDR" {
+		return errors.New("metadata: PNG file does not start with IHDR")
+	}
+
+	var extra []Chunk
+	if len(m.ICC) > 0 {
+		name := m.ICCName
+		if name == "" {
+			name = defaultICCName
+		}
+		var data bytes.Buffer
+		data.WriteString(name)
+		data.Write([]byte{0, 0}) // Separator and compression method
+		zw, err := zlib.NewWriterLevel(&data, zlib.BestCompression)
+		if err != nil {
+			return err
+		}
+		zw.Write(m.ICC)
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		extra = append(extra, Chunk{"iCCP", data.Bytes()})
+	}
+	if len(m.EXIF) > 0 {
+		extra = append(extra, Chunk{"eXIf", m.EXIF})
+	}
+	if len(m.XMP) > 0 {
+		data := append([]byte(xmpKeyword), 0, 0, 0, 0, 0)
+		extra = append(extra, Chunk{"iTXt", append(data, m.XMP...)})
+	}
+	extra = append(extra, m.Text...)
+
+	if _, err := w.Write(pngMagic); err != nil {
+		return err
+	}
+	for i, c := range chunks {
+		if err := writeChunk(w, c); err != nil {
+			return err
+		}
+		if i > 0 {
+			continue
+		}
+		for _, e := range extra {
+			if err := writeChunk(w, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeChunk writes a PNG chunk with its length and CRC
+func writeChunk(w io.Writer, c Chunk) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(c.Data)))
+	copy(header[4:], c.Type)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(c.Data)
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(c.Data); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
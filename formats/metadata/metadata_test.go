@@ -0,0 +1,113 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/ashish0kumar/tint/formats/exif"
+)
+
+// encodedPNG returns a 1x1 PNG
+func encodedPNG(t *testing.T) []byte {
+	var b bytes.Buffer
+	if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// deflate compresses data with zlib
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&b, zlib.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestPNGRoundTrip(t *testing.T) {
+	in := &Metadata{
+		EXIF:    []byte("MM\x00*\x00\x00\x00\x08\x00\x00"),
+		XMP:     []byte("<x:xmpmeta/>"),
+		ICC:     bytes.Repeat([]byte{1, 2, 3}, 1000),
+		ICCName: "Display P3",
+		Text:    []Chunk{{"tEXt", []byte("Author\x00someone")}},
+	}
+	out, err := roundTripPNG(t, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.EXIF, in.EXIF) || !bytes.Equal(out.XMP, in.XMP) || !bytes.Equal(out.ICC, in.ICC) || out.ICCName != in.ICCName {
+		t.Errorf("FromPNG = %+v, want %+v", out, in)
+	}
+	if len(out.Text) != 1 || !bytes.Equal(out.Text[0].Data, in.Text[0].Data) {
+		t.Errorf("FromPNG text = %q, want %q", out.Text, in.Text)
+	}
+}
+
+func TestFromPNGInflateLimit(t *testing.T) {
+	bomb := deflate(make([]byte, MaxICCSize+1))
+	if len(bomb) > 8<<10 {
+		t.Fatalf("test data is %d bytes, expected a small zlib bomb", len(bomb))
+	}
+
+	tests := []struct {
+		name  string
+		chunk Chunk
+	}{
+		{"iCCP", Chunk{"iCCP", append([]byte("bomb\x00\x00"), bomb...)}},
+		{"iTXt", Chunk{"iTXt", append([]byte(xmpKeyword+"\x00\x01\x00\x00\x00"), deflate(make([]byte, MaxXMPSize+1))...)}},
+	}
+	for _, tt := range tests {
+		encoded := encodedPNG(t)
+		var b bytes.Buffer
+		b.Write(encoded[:33]) // Signature and IHDR
+		if err := writeChunk(&b, tt.chunk); err != nil {
+			t.Fatal(err)
+		}
+		b.Write(encoded[33:])
+		if _, err := FromPNG(b.Bytes()); err == nil {
+			t.Errorf("%s: FromPNG accepted data inflating beyond the limit", tt.name)
+		}
+	}
+
+	// Data at the limit is still accepted
+	m, err := roundTripPNG(t, &Metadata{ICC: make([]byte, MaxICCSize)})
+	if err != nil || len(m.ICC) != MaxICCSize {
+		t.Errorf("FromPNG with a %d byte profile: %v", MaxICCSize, err)
+	}
+}
+
+// roundTripPNG writes m into a PNG, checks that it still decodes and reads m back
+func roundTripPNG(t *testing.T, m *Metadata) (*Metadata, error) {
+	var b bytes.Buffer
+	if err := m.WritePNG(&b, encodedPNG(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatalf("output is not a valid PNG: %v", err)
+	}
+	return FromPNG(b.Bytes())
+}
+
+func TestResetOrientation(t *testing.T) {
+	// Orientation 6 in EXIF, and in XMP as an attribute and as an element
+	m := &Metadata{
+		EXIF: []byte("MM\x00*\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00"),
+		XMP: []byte(`<rdf:Description tiff:Orientation="6" tiff:Make="x"/>` +
+			`<rdf:Description><tiff:Orientation> 6 </tiff:Orientation></rdf:Description>`),
+	}
+	m.ResetOrientation()
+
+	if o := exif.Orientation(m.EXIF); o != 1 {
+		t.Errorf("EXIF orientation = %d, want 1", o)
+	}
+	want := `<rdf:Description tiff:Orientation="1" tiff:Make="x"/>` +
+		`<rdf:Description><tiff:Orientation> 1 </tiff:Orientation></rdf:Description>`
+	if string(m.XMP) != want {
+		t.Errorf("XMP = %s, want %s", m.XMP, want)
+	}
+}
@@ -0,0 +1,83 @@
+package pnm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	translucent := image.NewNRGBA(image.Rect(0, 0, 5, 4))
+	for i := range translucent.Pix {
+		translucent.Pix[i] = uint8(i*29 + 3)
+	}
+	opaque := image.NewRGBA(image.Rect(0, 0, 5, 4))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = uint8(i * 13)
+		if i%4 == 3 {
+			opaque.Pix[i] = 0xff
+		}
+	}
+
+	tests := []struct {
+		format string
+		encode func(io.Writer, image.Image) error
+		img    image.Image
+		model  color.Model
+	}{
+		{"ppm", EncodePPM, opaque, color.RGBAModel},
+		{"pgm", EncodePGM, opaque, color.GrayModel},
+		{"pam", EncodePAM, translucent, color.NRGBAModel},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := tt.encode(&b, tt.img); err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		out, format, err := image.Decode(&b)
+		if err != nil || format != tt.format {
+			t.Fatalf("%s: image.Decode = %s, %v", tt.format, format, err)
+		}
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 5; x++ {
+				want := tt.model.Convert(tt.img.At(x, y))
+				if got := tt.model.Convert(out.At(x, y)); got != want {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", tt.format, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodePlainAndWide(t *testing.T) {
+	tests := []struct {
+		data string
+		want color.Color
+	}{
+		{"P2 # gray\n1 1\n# maxval follows\n4\n4\n", color.White},
+		{"P3\n1 1\n255\n255 0 0\n", color.RGBA{0xff, 0, 0, 0xff}},
+		{"P6\n1 1\n65535\n\x12\x34\x56\x78\x9a\xbc", color.RGBA64{0x1234, 0x5678, 0x9abc, 0xffff}},
+	}
+	for _, tt := range tests {
+		img, err := Decode(strings.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("Decode(%q): %v", tt.data, err)
+			continue
+		}
+		got := color.RGBA64Model.Convert(img.At(0, 0))
+		if want := color.RGBA64Model.Convert(tt.want); got != want {
+			t.Errorf("Decode(%q) pixel = %v, want %v", tt.data, got, want)
+		}
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range []string{"", "P6\n0 1\n255\n", "P6\n2 1\n255\n\x00\x00\x00", "P2\n1 1\n3\n4\n"} {
+		if _, err := Decode(strings.NewReader(data)); err == nil {
+			t.Errorf("Decode(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package pnm reads and writes the Netpbm PGM, PPM and PAM formats
+//
+// Both the plain (P2, P3) and raw (P5, P6) variants of PGM and PPM are read, as
+// well as PAM (P7) with any of the standard tuple types. Samples of up to 16 bits
+// are supported. The formats are registered with the image package under the
+// names "pgm", "ppm" and "pam".
+package pnm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	image.RegisterFormat("pgm", "P2", Decode, DecodeConfig)
+	image.RegisterFormat("pgm", "P5", Decode, DecodeConfig)
+	image.RegisterFormat("ppm", "P3", Decode, DecodeConfig)
+	image.RegisterFormat("ppm", "P6", Decode, DecodeConfig)
+	image.RegisterFormat("pam", "P7", Decode, DecodeConfig)
+}
+
+// header holds the fields of a Netpbm header
+type header struct {
+	magic         string
+	width, height int
+	depth         int // Number of samples per pixel
+	maxval        int
+	tupleType     string
+	alpha         bool // The last sample of each pixel is alpha
+}
+
+// readHeader parses a PGM, PPM or PAM header, leaving r at the first sample
+func readHeader(r *bufio.Reader) (header, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header{}, err
+	}
+	h := header{magic: string(magic)}
+
+	switch h.magic {
+	case "P2", "P5":
+		h.depth = 1
+	case "P3", "P6":
+		h.depth = 3
+	case "P7":
+		return readPAMHeader(r, h)
+	default:
+		return header{}, fmt.Errorf("pnm: unsupported magic number '%s'", h.magic)
+	}
+
+	values := make([]int, 3)
+	for i := range values {
+		token, err := readToken(r)
+		if err != nil {
+			return header{}, fmt.Errorf("pnm: invalid header: %w", err)
+		}
+		v, err := strconv.Atoi(token)
+		if err != nil || v <= 0 {
+			return header{}, fmt.Errorf("pnm: invalid header value '%s'", token)
+		}
+		values[i] = v
+	}
+	h.width, h.height, h.maxval = values[0], values[1], values[2]
+	if h.maxval > 65535 {
+		return header{}, fmt.Errorf("pnm: maxval %d exceeds 65535", h.maxval)
+	}
+
+	// readToken has consumed the single whitespace character that
+	// separates the header from raw samples
+	return h, nil
+}
+
+// readPAMHeader parses the header lines of a PAM file up to ENDHDR
+func readPAMHeader(r *bufio.Reader, h header) (header, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return header{}, fmt.Errorf("pnm: invalid pam header: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if len(fields) < 2 {
+			return header{}, fmt.Errorf("pnm: invalid pam header line '%s'", strings.TrimSpace(line))
+		}
+
+		switch fields[0] {
+		case "TUPLTYPE":
+			h.tupleType = strings.Join(fields[1:], " ")
+		case "WIDTH", "HEIGHT", "DEPTH", "MAXVAL":
+			v, err := strconv.Atoi(fields[1])
+			if err != nil || v <= 0 {
+				return header{}, fmt.Errorf("pnm: invalid %s '%s'", fields[0], fields[1])
+			}
+			switch fields[0] {
+			case "WIDTH":
+				h.width = v
+			case "HEIGHT":
+				h.height = v
+			case "DEPTH":
+				h.depth = v
+			case "MAXVAL":
+				h.maxval = v
+			}
+		default:
+			return header{}, fmt.Errorf("pnm: unknown pam header field '%s'", fields[0])
+		}
+	}
+
+	if h.width == 0 || h.height == 0 || h.depth == 0 || h.maxval == 0 {
+		return header{}, errors.New("pnm: pam header is missing WIDTH, HEIGHT, DEPTH or MAXVAL")
+	}
+	if h.maxval > 65535 {
+		return header{}, fmt.Errorf("pnm: maxval %d exceeds 65535", h.maxval)
+	}
+
+	switch {
+	case h.tupleType == "GRAYSCALE_ALPHA" && h.depth == 2,
+		h.tupleType == "RGB_ALPHA" && h.depth == 4,
+		h.tupleType == "BLACKANDWHITE_ALPHA" && h.depth == 2:
+		h.alpha = true
+	case h.tupleType == "GRAYSCALE" && h.depth == 1,
+		h.tupleType == "RGB" && h.depth == 3,
+		h.tupleType == "BLACKANDWHITE" && h.depth == 1:
+	case h.tupleType == "" && h.depth >= 1 && h.depth <= 4:
+		// Without a tuple type, guess from the depth
+		h.alpha = h.depth == 2 || h.depth == 4
+	default:
+		return header{}, fmt.Errorf("pnm: unsupported pam tuple type '%s' with depth %d", h.tupleType, h.depth)
+	}
+	return h, nil
+}
+
+// readToken returns the next whitespace-separated token of a plain header or sample list
+// Comments from '#' to the end of the line are skipped
+func readToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(token) > 0 {
+				return string(token), nil
+			}
+			return "", err
+		}
+		switch {
+		case b == '#':
+			if _, err := r.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, b)
+		}
+	}
+}
+
+// DecodeConfig returns the dimensions and color model of a PGM, PPM or PAM image
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: h.colorModel(), Width: h.width, Height: h.height}, nil
+}
+
+// colorModel returns the color model of the image Decode returns for h
+func (h header) colorModel() color.Model {
+	wide := h.maxval > 255
+	switch {
+	case h.depth <= 2 && !h.alpha:
+		if wide {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case !h.alpha:
+		if wide {
+			return color.RGBA64Model
+		}
+		return color.RGBAModel
+	default:
+		if wide {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	}
+}
+
+// Decode reads a PGM, PPM or PAM image
+// Grayscale images decode to *image.Gray, color images to *image.RGBA and images
+// with alpha to *image.NRGBA, or their 16-bit variants when maxval exceeds 255
+func Decode(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := sampleReader(br, h)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := image.Rect(0, 0, h.width, h.height)
+	var dst image.Image
+	var set func(x, y int, s [4]uint16)
+	switch m := h.colorModel(); m {
+	case color.GrayModel:
+		img := image.NewGray(rect)
+		dst, set = img, func(x, y int, s [4]uint16) { img.SetGray(x, y, color.Gray{uint8(s[0] >> 8)}) }
+	case color.Gray16Model:
+		img := image.NewGray16(rect)
+		dst, set = img, func(x, y int, s [4]uint16) { img.SetGray16(x, y, color.Gray16{s[0]}) }
+	case color.RGBAModel:
+		img := image.NewRGBA(rect)
+		dst, set = img, func(x, y int, s [4]uint16) {
+			img.SetRGBA(x, y, color.RGBA{uint8(s[0] >> 8), uint8(s[1] >> 8), uint8(s[2] >> 8), 0xff})
+		}
+	case color.RGBA64Model:
+		img := image.NewRGBA64(rect)
+		dst, set = img, func(x, y int, s [4]uint16) { img.SetRGBA64(x, y, color.RGBA64{s[0], s[1], s[2], 0xffff}) }
+	case color.NRGBAModel:
+		img := image.NewNRGBA(rect)
+		dst, set = img, func(x, y int, s [4]uint16) {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(s[0] >> 8), uint8(s[1] >> 8), uint8(s[2] >> 8), uint8(s[3] >> 8)})
+		}
+	default:
+		img := image.NewNRGBA64(rect)
+		dst, set = img, func(x, y int, s [4]uint16) { img.SetNRGBA64(x, y, color.NRGBA64{s[0], s[1], s[2], s[3]}) }
+	}
+
+	var raw [4]uint16
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			for i := 0; i < h.depth; i++ {
+				v, err := next()
+				if err != nil {
+					return nil, fmt.Errorf("pnm: reading pixel (%d, %d): %w", x, y, err)
+				}
+				raw[i] = v
+			}
+			set(x, y, expand(raw, h))
+		}
+	}
+	return dst, nil
+}
+
+// expand arranges the samples of one pixel as 16-bit gray or RGB values plus alpha
+func expand(raw [4]uint16, h header) [4]uint16 {
+	var s [4]uint16
+	switch {
+	case h.depth <= 2:
+		s[0], s[1], s[2] = raw[0], raw[0], raw[0]
+	default:
+		s[0], s[1], s[2] = raw[0], raw[1], raw[2]
+	}
+	s[3] = 0xffff
+	if h.alpha {
+		s[3] = raw[h.depth-1]
+	}
+	return s
+}
+
+// sampleReader returns a function reading successive samples scaled to 16 bits
+func sampleReader(r *bufio.Reader, h header) (func() (uint16, error), error) {
+	// Black and white PAM images use 0 and 1, where 1 is white
+	if strings.HasPrefix(h.tupleType, "BLACKANDWHITE") {
+		h.maxval = 1
+	}
+
+	scale := func(v int) (uint16, error) {
+		if v > h.maxval {
+			return 0, fmt.Errorf("sample %d exceeds maxval %d", v, h.maxval)
+		}
+		return uint16((v*0xffff + h.maxval/2) / h.maxval), nil
+	}
+
+	switch {
+	case h.magic == "P2" || h.magic == "P3":
+		return func() (uint16, error) {
+			token, err := readToken(r)
+			if err != nil {
+				return 0, err
+			}
+			v, err := strconv.Atoi(token)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid sample '%s'", token)
+			}
+			return scale(v)
+		}, nil
+	case h.maxval > 255:
+		var buf [2]byte
+		return func() (uint16, error) {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return 0, err
+			}
+			return scale(int(buf[0])<<8 | int(buf[1]))
+		}, nil
+	default:
+		return func() (uint16, error) {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			return scale(int(b))
+		}, nil
+	}
+}
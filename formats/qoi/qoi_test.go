@@ -0,0 +1,58 @@
+package qoi
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 31, 17))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	// Small steps and a run longer than 62 pixels use the diff, luma and run operations
+	steps := image.NewRGBA(image.Rect(0, 0, 100, 3))
+	for x := 0; x < 100; x++ {
+		v := uint8(min(x, 50) * 3)
+		steps.SetRGBA(x, 0, color.RGBA{v, v, v, 0xff})
+		steps.SetRGBA(x, 1, color.RGBA{v, v + 20, v + 10, 0xff})
+		steps.SetRGBA(x, 2, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	}
+
+	for _, img := range []image.Image{noise, steps} {
+		var b bytes.Buffer
+		if err := Encode(&b, img); err != nil {
+			t.Fatal(err)
+		}
+		out, format, err := image.Decode(&b)
+		if err != nil || format != "qoi" {
+			t.Fatalf("image.Decode = %s, %v", format, err)
+		}
+		bounds := img.Bounds()
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				got := color.NRGBAModel.Convert(out.At(x, y))
+				if want := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+					t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	// An RGB operation, then a run of the two repeated pixels
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x := 0; x < 3; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{0x2e, 0x34, 0x40, 0xff})
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("qoif\x00\x00\x00\x03\x00\x00\x00\x01\x03\x00\xfe\x2e\x34\x40\xc1\x00\x00\x00\x00\x00\x00\x00\x01")
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("Encode = %x, want %x", b.Bytes(), want)
+	}
+}
@@ -0,0 +1,147 @@
+// Package qoi reads and writes images in the Quite OK Image format
+//
+// See https://qoiformat.org/qoi-specification.pdf. Images are decoded as
+// image.NRGBA whether the file has three or four channels, and the format is
+// registered with the image package under the name "qoi".
+package qoi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+const (
+	magic      = "qoif"
+	headerSize = 14
+	maxPixels  = 400000000 // Limit from the specification, guards against overflow
+
+	opIndex = 0x00 // 00xxxxxx
+	opDiff  = 0x40 // 01xxxxxx
+	opLuma  = 0x80 // 10xxxxxx
+	opRun   = 0xc0 // 11xxxxxx
+	opRGB   = 0xfe
+	opRGBA  = 0xff
+	opMask  = 0xc0
+)
+
+// endMarker follows the last chunk of every file
+var endMarker = []byte{0, 0, 0, 0, 0, 0, 0, 1}
+
+func init() {
+	image.RegisterFormat("qoi", magic, Decode, DecodeConfig)
+}
+
+// header holds the fields of a QOI header
+type header struct {
+	width, height int
+	channels      int // 3 for RGB, 4 for RGBA
+	colorspace    int // 0 for sRGB with linear alpha, 1 for all channels linear
+}
+
+// readHeader parses the 14-byte QOI header
+func readHeader(r io.Reader) (header, error) {
+	var buf [headerSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return header{}, err
+	}
+	if string(buf[:4]) != magic {
+		return header{}, errors.New("qoi: invalid magic")
+	}
+	h := header{
+		width:      int(binary.BigEndian.Uint32(buf[4:])),
+		height:     int(binary.BigEndian.Uint32(buf[8:])),
+		channels:   int(buf[12]),
+		colorspace: int(buf[13]),
+	}
+	if h.width == 0 || h.height == 0 || h.width > maxPixels || h.height > maxPixels || h.width*h.height > maxPixels {
+		return header{}, fmt.Errorf("qoi: invalid image size %dx%d", h.width, h.height)
+	}
+	if h.channels != 3 && h.channels != 4 {
+		return header{}, fmt.Errorf("qoi: invalid channel count %d", h.channels)
+	}
+	if h.colorspace > 1 {
+		return header{}, fmt.Errorf("qoi: invalid colorspace %d", h.colorspace)
+	}
+	return h, nil
+}
+
+// DecodeConfig returns the color model and dimensions of a QOI image without decoding it
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBAModel, Width: h.width, Height: h.height}, nil
+}
+
+// Decode reads a QOI image from r
+func Decode(r io.Reader) (image.Image, error) {
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, h.width, h.height))
+	var index [64][4]byte
+	px := [4]byte{0, 0, 0, 0xff}
+	run, i := 0, 0
+	for p := 0; p < len(img.Pix); p += 4 {
+		if run > 0 {
+			run--
+		} else {
+			if i >= len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			b := data[i]
+			i++
+			switch {
+			case b == opRGB:
+				if i+3 > len(data) {
+					return nil, io.ErrUnexpectedEOF
+				}
+				px[0], px[1], px[2] = data[i], data[i+1], data[i+2]
+				i += 3
+			case b == opRGBA:
+				if i+4 > len(data) {
+					return nil, io.ErrUnexpectedEOF
+				}
+				px = [4]byte(data[i : i+4])
+				i += 4
+			case b&opMask == opIndex:
+				px = index[b]
+			case b&opMask == opDiff:
+				px[0] += (b>>4)&3 - 2
+				px[1] += (b>>2)&3 - 2
+				px[2] += b&3 - 2
+			case b&opMask == opLuma:
+				if i >= len(data) {
+					return nil, io.ErrUnexpectedEOF
+				}
+				b2 := data[i]
+				i++
+				dg := b&0x3f - 32
+				px[0] += dg + b2>>4 - 8
+				px[1] += dg
+				px[2] += dg + b2&0x0f - 8
+			case b&opMask == opRun:
+				run = int(b & 0x3f)
+			}
+			index[hash(px)] = px
+		}
+		img.Pix[p+0], img.Pix[p+1], img.Pix[p+2], img.Pix[p+3] = px[0], px[1], px[2], px[3]
+	}
+	return img, nil
+}
+
+// hash returns the position of a pixel in the running color index
+func hash(px [4]byte) int {
+	return (int(px[0])*3 + int(px[1])*5 + int(px[2])*7 + int(px[3])*11) % 64
+}
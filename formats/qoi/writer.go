@@ -0,0 +1,86 @@
+package qoi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Encode writes img to w as a QOI image
+// Images without transparency are written with three channels, others with four.
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	pix := make([]byte, 0, 4*b.Dx()*b.Dy())
+	if m, ok := img.(*image.NRGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := m.PixOffset(b.Min.X, y)
+			pix = append(pix, m.Pix[i:i+4*b.Dx()]...)
+		}
+	} else {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				pix = append(pix, c.R, c.G, c.B, c.A)
+			}
+		}
+	}
+	channels := byte(3)
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0xff {
+			channels = 4
+			break
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	var hdr [headerSize]byte
+	copy(hdr[:], magic)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(b.Dy()))
+	hdr[12] = channels
+	bw.Write(hdr[:])
+
+	var index [64][4]byte
+	prev := [4]byte{0, 0, 0, 0xff}
+	run := 0
+	for p := 0; p < len(pix); p += 4 {
+		px := [4]byte(pix[p : p+4])
+		if px == prev {
+			run++
+			if run == 62 || p+4 == len(pix) {
+				bw.WriteByte(opRun | byte(run-1))
+				run = 0
+			}
+			continue
+		}
+		if run > 0 {
+			bw.WriteByte(opRun | byte(run-1))
+			run = 0
+		}
+
+		h := hash(px)
+		switch {
+		case index[h] == px:
+			bw.WriteByte(opIndex | byte(h))
+		case px[3] != prev[3]:
+			bw.Write([]byte{opRGBA, px[0], px[1], px[2], px[3]})
+		default:
+			dr, dg, db := int8(px[0]-prev[0]), int8(px[1]-prev[1]), int8(px[2]-prev[2])
+			drg, dbg := dr-dg, db-dg
+			switch {
+			case dr >= -2 && dr <= 1 && dg >= -2 && dg <= 1 && db >= -2 && db <= 1:
+				bw.WriteByte(opDiff | byte(dr+2)<<4 | byte(dg+2)<<2 | byte(db+2))
+			case dg >= -32 && dg <= 31 && drg >= -8 && drg <= 7 && dbg >= -8 && dbg <= 7:
+				bw.Write([]byte{opLuma | byte(dg+32), byte(drg+8)<<4 | byte(dbg+8)})
+			default:
+				bw.Write([]byte{opRGB, px[0], px[1], px[2]})
+			}
+		}
+		index[h] = px
+		prev = px
+	}
+	bw.Write(endMarker)
+	return bw.Flush()
+}
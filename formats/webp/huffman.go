@@ -0,0 +1,281 @@
+package webp
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Limits on code lengths imposed by the VP8L format
+const (
+	maxCodeLength           = 15 // Longest code in the main prefix codes
+	maxCodeLengthCodeLength = 7  // Longest code in the code length code
+)
+
+// codeLengthCodeOrder is the order in which the code length code is written
+var codeLengthCodeOrder = [19]int{
+	17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// bitWriter packs values least significant bit first, as VP8L expects
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nAcc uint
+}
+
+// write appends the low n bits of v, n must be at most 32
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nAcc
+	w.nAcc += n
+	for w.nAcc >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nAcc -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns the written data
+func (w *bitWriter) bytes() []byte {
+	if w.nAcc > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nAcc = 0, 0
+	}
+	return w.buf
+}
+
+// prefixCode is a canonical prefix code ready for writing symbols
+// Codes are stored bit-reversed, so they can be passed to bitWriter.write as is.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+// writeSymbol writes the code for symbol s
+func (c *prefixCode) writeSymbol(w *bitWriter, s int) {
+	w.write(uint32(c.codes[s]), uint(c.lengths[s]))
+}
+
+// huffmanLengths returns optimal code lengths for counts, without a length limit
+func huffmanLengths(counts []int) []uint8 {
+	type node struct {
+		count  int
+		parent int
+	}
+	var leaves []int
+	for s, n := range counts {
+		if n > 0 {
+			leaves = append(leaves, s)
+		}
+	}
+	lengths := make([]uint8, len(counts))
+	if len(leaves) < 2 {
+		for _, s := range leaves {
+			lengths[s] = 1
+		}
+		return lengths
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return counts[leaves[i]] < counts[leaves[j]] })
+
+	// Two-queue construction: leaves are sorted, and merged nodes are created in
+	// increasing order of count, so the smallest node is always at one of the heads
+	nodes := make([]node, 0, 2*len(leaves)-1)
+	for _, s := range leaves {
+		nodes = append(nodes, node{count: counts[s], parent: -1})
+	}
+	nextLeaf, nextMerged := 0, len(leaves)
+	pick := func() int {
+		if nextLeaf < len(leaves) && (nextMerged >= len(nodes) || nodes[nextLeaf].count <= nodes[nextMerged].count) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextMerged++
+		return nextMerged - 1
+	}
+	for i := 0; i < len(leaves)-1; i++ {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+
+	// Parents always come after their children, so depths can be filled in backwards
+	depth := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+	for i, s := range leaves {
+		lengths[s] = depth[i]
+	}
+	return lengths
+}
+
+// buildPrefixCode builds a canonical prefix code for counts with no code longer than maxLength
+// Counts are halved until the optimal code fits, as libwebp does. A single used
+// symbol gets a length of 1 in lengths but is written with zero bits, matching
+// how decoders treat a code with only one symbol.
+func buildPrefixCode(counts []int, maxLength int) (lengths []uint8, code prefixCode) {
+	scaled := append([]int(nil), counts...)
+	for {
+		lengths = huffmanLengths(scaled)
+		longest := 0
+		for _, l := range lengths {
+			longest = max(longest, int(l))
+		}
+		if longest <= maxLength {
+			break
+		}
+		for i, n := range scaled {
+			if n > 0 {
+				scaled[i] = (n + 1) / 2
+			}
+		}
+	}
+
+	code = prefixCode{lengths: make([]uint8, len(lengths)), codes: make([]uint16, len(lengths))}
+	used := 0
+	for _, l := range lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		return lengths, code
+	}
+
+	// Assign canonical codes in order of length, then symbol
+	var histogram [maxCodeLength + 1]int
+	for _, l := range lengths {
+		histogram[l]++
+	}
+	histogram[0] = 0
+	var next [maxCodeLength + 1]int
+	for l, c := 1, 0; l <= maxCodeLength; l++ {
+		c = (c + histogram[l-1]) << 1
+		next[l] = c
+	}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		code.lengths[s] = l
+		code.codes[s] = uint16(bits.Reverse16(uint16(next[l])) >> (16 - l))
+		next[l]++
+	}
+	return lengths, code
+}
+
+// writePrefixCode writes the code for an alphabet with the given symbol counts and returns it
+func writePrefixCode(w *bitWriter, counts []int) prefixCode {
+	var symbols []int
+	for s, n := range counts {
+		if n > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+
+	// Up to two symbols below 256 fit in a simple code
+	if len(symbols) <= 2 && (len(symbols) == 0 || symbols[len(symbols)-1] < 256) {
+		code := prefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint16, len(counts))}
+		if len(symbols) == 0 {
+			symbols = []int{0}
+		}
+		w.write(1, 1)
+		w.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(symbols[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			w.write(uint32(symbols[1]), 8)
+			code.lengths[symbols[0]], code.codes[symbols[0]] = 1, 0
+			code.lengths[symbols[1]], code.codes[symbols[1]] = 1, 1
+		}
+		return code
+	}
+
+	lengths, code := buildPrefixCode(counts, maxCodeLength)
+	tokens := codeLengthTokens(lengths)
+
+	var clCounts [19]int
+	for _, t := range tokens {
+		clCounts[t.symbol]++
+	}
+	clLengths, clCode := buildPrefixCode(clCounts[:], maxCodeLengthCodeLength)
+	numCodes := len(codeLengthCodeOrder)
+	for numCodes > 4 && clLengths[codeLengthCodeOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+
+	w.write(0, 1)
+	w.write(uint32(numCodes-4), 4)
+	for _, s := range codeLengthCodeOrder[:numCodes] {
+		w.write(uint32(clLengths[s]), 3)
+	}
+	w.write(0, 1) // Code lengths are given for the whole alphabet
+	for _, t := range tokens {
+		clCode.writeSymbol(w, t.symbol)
+		switch t.symbol {
+		case 16:
+			w.write(uint32(t.extra), 2)
+		case 17:
+			w.write(uint32(t.extra), 3)
+		case 18:
+			w.write(uint32(t.extra), 7)
+		}
+	}
+	return code
+}
+
+// codeLengthToken is a symbol of the code length code with its extra bits
+type codeLengthToken struct {
+	symbol int
+	extra  int
+}
+
+// codeLengthTokens run-length encodes code lengths with the repeat symbols 16, 17 and 18
+func codeLengthTokens(lengths []uint8) []codeLengthToken {
+	var tokens []codeLengthToken
+	prev := uint8(8) // Symbol 16 repeats 8 until a non-zero length has been written
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		if l == 0 {
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, codeLengthToken{18, n - 11})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, codeLengthToken{17, run - 3})
+				run = 0
+			}
+			for ; run > 0; run-- {
+				tokens = append(tokens, codeLengthToken{0, 0})
+			}
+			continue
+		}
+
+		if l != prev {
+			tokens = append(tokens, codeLengthToken{int(l), 0})
+			prev = l
+			run--
+		}
+		for run >= 3 {
+			n := min(run, 6)
+			tokens = append(tokens, codeLengthToken{16, n - 3})
+			run -= n
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, codeLengthToken{int(l), 0})
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,68 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestRoundTrip(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	// Smooth gradients and repeated rows exercise the predictors and backward references
+	gradient := image.NewRGBA(image.Rect(5, 7, 105, 77))
+	for y := 7; y < 77; y++ {
+		for x := 5; x < 105; x++ {
+			gradient.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y / 4 * 4), uint8(x + y), 0xff})
+		}
+	}
+
+	for _, img := range []image.Image{noise, gradient} {
+		var b bytes.Buffer
+		if err := Encode(&b, img); err != nil {
+			t.Fatal(err)
+		}
+		out, err := webp.Decode(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bounds := img.Bounds()
+		if out.Bounds().Size() != bounds.Size() {
+			t.Fatalf("size %v, want %v", out.Bounds().Size(), bounds.Size())
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				got := color.NRGBAModel.Convert(out.At(x, y))
+				want := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+				if got != want {
+					t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestBuildPrefixCodeLimit(t *testing.T) {
+	// Fibonacci counts give the deepest possible Huffman tree
+	counts := make([]int, 40)
+	a, b := 1, 1
+	for i := range counts {
+		counts[i] = a
+		a, b = b, a+b
+	}
+	lengths, _ := buildPrefixCode(counts, maxCodeLength)
+	kraft := 0.0
+	for s, l := range lengths {
+		if l == 0 || int(l) > maxCodeLength {
+			t.Fatalf("symbol %d has length %d", s, l)
+		}
+		kraft += 1 / float64(uint64(1)<<l)
+	}
+	if kraft != 1 {
+		t.Errorf("code lengths give a Kraft sum of %v, want a complete code", kraft)
+	}
+}
@@ -0,0 +1,436 @@
+// Package webp writes lossless WebP images
+//
+// The encoder produces a VP8L bitstream using the subtract green and predictor
+// transforms, LZ77 backward references and canonical prefix codes. Decoding is
+// left to golang.org/x/image/webp, which handles both lossy and lossless files.
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// MaxDimension is the largest width or height a VP8L image can have
+const MaxDimension = 1 << 14
+
+const (
+	predictorBits = 4 // Predictor tiles are 16x16 pixels
+
+	// Alphabet sizes of the five prefix codes used for each pixel
+	numLiteralCodes  = 256
+	numLengthCodes   = 24
+	numDistanceCodes = 40
+
+	minMatch       = 3    // Shortest backward reference worth emitting
+	maxMatch       = 4096 // Longest backward reference the format allows
+	maxChainLength = 32   // Hash chain entries examined per pixel
+	hashBits       = 16
+	windowSize     = 1<<20 - 120 // Largest distance that fits in a distance code
+)
+
+// Encode writes img to w as a lossless WebP
+func Encode(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("webp: invalid image size %dx%d", width, height)
+	}
+	if width > MaxDimension || height > MaxDimension {
+		return fmt.Errorf("webp: image size %dx%d exceeds the maximum of %d", width, height, MaxDimension)
+	}
+
+	pix, hasAlpha := nrgbaPixels(img)
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // Version
+
+	// Transforms are undone by the decoder in reverse order, so they are
+	// written in the order they are applied here
+	subtractGreen(pix)
+	bw.write(1, 1)
+	bw.write(2, 2)
+
+	tiles, tilesWide, tilesHigh := applyPredictor(pix, width, height)
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(predictorBits-2, 3)
+	writeImage(bw, tiles, tilesWide, tilesHigh, false)
+
+	bw.write(0, 1) // No more transforms
+	writeImage(bw, pix, width, height, true)
+
+	return writeContainer(w, bw.bytes())
+}
+
+// writeContainer wraps a VP8L bitstream in a RIFF WebP container
+func writeContainer(w io.Writer, data []byte) error {
+	padded := len(data) + len(data)&1
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+padded))
+	copy(header[8:], "WEBP")
+	copy(header[12:], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padded != len(data) {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// nrgbaPixels returns the non-premultiplied RGBA bytes of img and whether any pixel is not opaque
+func nrgbaPixels(img image.Image) ([]byte, bool) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	pix := make([]byte, 0, 4*width*height)
+	if m, ok := img.(*image.NRGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := m.PixOffset(b.Min.X, y)
+			pix = append(pix, m.Pix[i:i+4*width]...)
+		}
+	} else {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				pix = append(pix, c.R, c.G, c.B, c.A)
+			}
+		}
+	}
+	for i := 3; i < len(pix); i += 4 {
+		if pix[i] != 0xff {
+			return pix, true
+		}
+	}
+	return pix, false
+}
+
+// subtractGreen subtracts the green channel from red and blue
+func subtractGreen(pix []byte) {
+	for p := 0; p < len(pix); p += 4 {
+		pix[p+0] -= pix[p+1]
+		pix[p+2] -= pix[p+1]
+	}
+}
+
+// applyPredictor replaces pix with prediction residuals, choosing the best of the
+// 14 predictors for each tile, and returns the sub-image holding the tile modes
+func applyPredictor(pix []byte, width, height int) ([]byte, int, int) {
+	tilesWide := (width + 1<<predictorBits - 1) >> predictorBits
+	tilesHigh := (height + 1<<predictorBits - 1) >> predictorBits
+	tiles := make([]byte, 4*tilesWide*tilesHigh)
+
+	// Predictions use the original values, which the decoder reconstructs exactly
+	orig := append([]byte(nil), pix...)
+	stride := 4 * width
+
+	// The first pixel is predicted as opaque black, the rest of the first row
+	// from the left and the rest of the first column from above
+	pix[3] -= 0xff
+	for p := 4; p < stride; p++ {
+		pix[p] = orig[p] - orig[p-4]
+	}
+	for p := stride; p < len(pix); p += stride {
+		for c := 0; c < 4; c++ {
+			pix[p+c] = orig[p+c] - orig[p-stride+c]
+		}
+	}
+
+	// Tile rows are independent, so they are processed concurrently
+	var wg sync.WaitGroup
+	rows := make(chan int)
+	for range min(runtime.NumCPU(), tilesHigh) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ty := range rows {
+				for tx := 0; tx < tilesWide; tx++ {
+					x0, y0 := max(tx<<predictorBits, 1), max(ty<<predictorBits, 1)
+					x1, y1 := min((tx+1)<<predictorBits, width), min((ty+1)<<predictorBits, height)
+
+					// Pick the mode with the smallest sum of absolute residuals
+					var costs [14]int
+					for y := y0; y < y1; y++ {
+						for x := x0; x < x1; x++ {
+							p := y*stride + 4*x
+							preds := predictAll(orig, p, p-stride)
+							for mode := range preds {
+								for c := 0; c < 4; c++ {
+									r := int(int8(orig[p+c] - preds[mode][c]))
+									costs[mode] += max(r, -r)
+								}
+							}
+						}
+					}
+					best := 0
+					for mode, cost := range costs {
+						if cost < costs[best] {
+							best = mode
+						}
+					}
+					t := 4 * (ty*tilesWide + tx)
+					tiles[t+1] = byte(best)
+					tiles[t+3] = 0xff
+
+					for y := y0; y < y1; y++ {
+						for x := x0; x < x1; x++ {
+							p := y*stride + 4*x
+							pred := predictAll(orig, p, p-stride)[best]
+							for c := 0; c < 4; c++ {
+								pix[p+c] = orig[p+c] - pred[c]
+							}
+						}
+					}
+				}
+			}
+		}()
+	}
+	for ty := range tilesHigh {
+		rows <- ty
+	}
+	close(rows)
+	wg.Wait()
+
+	return tiles, tilesWide, tilesHigh
+}
+
+// predictAll returns the predictions of all 14 modes for the pixel at offset p,
+// where top is the offset of the pixel above it
+func predictAll(pix []byte, p, top int) [14][4]byte {
+	var out [14][4]byte
+	for c := 0; c < 4; c++ {
+		l, t, tl, tr := pix[p-4+c], pix[top+c], pix[top-4+c], pix[top+4+c]
+		out[1][c] = l
+		out[2][c] = t
+		out[3][c] = tr
+		out[4][c] = tl
+		out[5][c] = avg2(avg2(l, tr), t)
+		out[6][c] = avg2(l, tl)
+		out[7][c] = avg2(l, t)
+		out[8][c] = avg2(tl, t)
+		out[9][c] = avg2(t, tr)
+		out[10][c] = avg2(avg2(l, tl), avg2(t, tr))
+		out[12][c] = clampAddSubtractFull(l, t, tl)
+		out[13][c] = clampAddSubtractHalf(avg2(l, t), tl)
+	}
+	out[0][3] = 0xff
+
+	// Select picks whichever of L and T is closer to the gradient estimate L + T - TL
+	distL, distT := 0, 0
+	for c := 0; c < 4; c++ {
+		l, t, tl := int(pix[p-4+c]), int(pix[top+c]), int(pix[top-4+c])
+		distL += max(tl-t, t-tl)
+		distT += max(tl-l, l-tl)
+	}
+	if distL < distT {
+		out[11] = out[1]
+	} else {
+		out[11] = out[2]
+	}
+	return out
+}
+
+func avg2(a, b uint8) uint8 {
+	return uint8((int(a) + int(b)) / 2)
+}
+
+func clampAddSubtractFull(a, b, c uint8) uint8 {
+	return clampByte(int(a) + int(b) - int(c))
+}
+
+func clampAddSubtractHalf(a, b uint8) uint8 {
+	return clampByte(int(a) + (int(a)-int(b))/2)
+}
+
+func clampByte(x int) uint8 {
+	return uint8(min(max(x, 0), 255))
+}
+
+// token is a literal pixel or a backward reference in the entropy-coded image
+type token struct {
+	pixel  int // Offset of the literal pixel, or -1 for a backward reference
+	length int
+	dist   int // Distance code, after mapping through the distance table
+}
+
+// writeImage entropy-codes pix, a width x height image of RGBA bytes
+// The main image carries an extra bit saying it has no meta prefix codes.
+func writeImage(bw *bitWriter, pix []byte, width, height int, main bool) {
+	bw.write(0, 1) // No color cache
+	if main {
+		bw.write(0, 1)
+	}
+
+	tokens := backwardReferences(pix, width, height)
+
+	green := make([]int, numLiteralCodes+numLengthCodes)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	dist := make([]int, numDistanceCodes)
+	for _, t := range tokens {
+		if t.pixel >= 0 {
+			red[pix[t.pixel]]++
+			green[pix[t.pixel+1]]++
+			blue[pix[t.pixel+2]]++
+			alpha[pix[t.pixel+3]]++
+			continue
+		}
+		sym, _, _ := prefixEncode(t.length)
+		green[numLiteralCodes+sym]++
+		sym, _, _ = prefixEncode(t.dist)
+		dist[sym]++
+	}
+
+	greenCode := writePrefixCode(bw, green)
+	redCode := writePrefixCode(bw, red)
+	blueCode := writePrefixCode(bw, blue)
+	alphaCode := writePrefixCode(bw, alpha)
+	distCode := writePrefixCode(bw, dist)
+
+	for _, t := range tokens {
+		if t.pixel >= 0 {
+			greenCode.writeSymbol(bw, int(pix[t.pixel+1]))
+			redCode.writeSymbol(bw, int(pix[t.pixel]))
+			blueCode.writeSymbol(bw, int(pix[t.pixel+2]))
+			alphaCode.writeSymbol(bw, int(pix[t.pixel+3]))
+			continue
+		}
+		sym, n, extra := prefixEncode(t.length)
+		greenCode.writeSymbol(bw, numLiteralCodes+sym)
+		bw.write(extra, n)
+		sym, n, extra = prefixEncode(t.dist)
+		distCode.writeSymbol(bw, sym)
+		bw.write(extra, n)
+	}
+}
+
+// prefixEncode splits a length or distance code into a symbol and its extra bits
+func prefixEncode(v int) (symbol int, n uint, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	h := bits.Len(uint(d)) - 1
+	second := (d >> (h - 1)) & 1
+	n = uint(h - 1)
+	return 2*h + second, n, uint32(d - (2+second)<<n)
+}
+
+// backwardReferences splits pix into literals and LZ77 matches using a hash chain
+func backwardReferences(pix []byte, width, height int) []token {
+	numPixels := width * height
+	at := func(i int) uint32 { return binary.LittleEndian.Uint32(pix[4*i:]) }
+	hash := func(i int) int {
+		h := uint64(at(i))<<32 | uint64(at(i+1))
+		return int((h * 0x9e3779b97f4a7c15) >> (64 - hashBits))
+	}
+	matchLength := func(i, j int) int {
+		n := 0
+		for n < maxMatch && i+n < numPixels && at(i+n) == at(j+n) {
+			n++
+		}
+		return n
+	}
+
+	distCodes := distanceCodes(width)
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, numPixels)
+	insert := func(i int) {
+		if i+1 < numPixels {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	var tokens []token
+	for i := 0; i < numPixels; {
+		bestLen, bestDist := 0, 0
+		// Check the pixels to the left and above first, they have the cheapest distance codes
+		for _, d := range []int{1, width} {
+			if d <= i {
+				if n := matchLength(i, i-d); n > bestLen {
+					bestLen, bestDist = n, d
+				}
+			}
+		}
+		if i+1 < numPixels {
+			for j, steps := int(head[hash(i)]), 0; j >= 0 && steps < maxChainLength && i-j <= windowSize; j, steps = int(prev[j]), steps+1 {
+				if n := matchLength(i, j); n > bestLen {
+					bestLen, bestDist = n, i-j
+				}
+			}
+		}
+
+		if bestLen < minMatch {
+			tokens = append(tokens, token{pixel: 4 * i})
+			insert(i)
+			i++
+			continue
+		}
+		code, ok := distCodes[bestDist]
+		if !ok {
+			code = bestDist + len(distanceMapTable)
+		}
+		tokens = append(tokens, token{pixel: -1, length: bestLen, dist: code})
+		for k := 0; k < bestLen; k++ {
+			insert(i + k)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+// distanceMapTable maps the 120 short distance codes to (x, y) offsets, as in the VP8L specification
+var distanceMapTable = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// distanceCodes maps linear distances to the smallest short distance code for an image width
+func distanceCodes(width int) map[int]int {
+	codes := make(map[int]int, len(distanceMapTable))
+	for i, v := range distanceMapTable {
+		d := int(v>>4)*width + 8 - int(v&0xf)
+		if d < 1 {
+			continue
+		}
+		if _, ok := codes[d]; !ok {
+			codes[d] = i + 1
+		}
+	}
+	return codes
+}
@@ -1,3 +1,5 @@
 module github.com/ashish0kumar/tint
 
 go 1.23.2
+
+require golang.org/x/image v0.25.0
@@ -1,36 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
-	"image/jpeg"
-	"image/png"
+	"io"
+	"io/fs"
 	"log"
 	"math"
+	"math/rand/v2"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"sort"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/ashish0kumar/tint/formats/exif"
+	"github.com/ashish0kumar/tint/formats/icc"
+	"github.com/ashish0kumar/tint/formats/metadata"
+	"github.com/ashish0kumar/tint/recolor"
 	"github.com/ashish0kumar/tint/themes"
 )
 
 const (
-	MaxImageDimension = 10000    // Maximum allowed width or height of the image
-	MaxImagePixels    = 50000000 // Maximum allowed number of pixels in the image (~7071x7071)
-
-	// Default params for Shepard's Method
-	defaultLuminosity = 1.0
-	defaultNearest    = 30
-	defaultPower      = 4.0
-
 	// ANSI escape codes for formatting
 	bold      = "\033[1m"
 	underline = "\033[4m"
@@ -39,405 +38,214 @@ const (
 
 var version = "dev"
 
-// toRGBA converts any color.Color to color.RGBA
-func toRGBA(c color.Color) color.RGBA {
-	r, g, b, a := c.RGBA()
-	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
-}
-
-// colorDistanceSquared calculates the squared euclidean distance between two colors in RGB space
-func colorDistanceSquared(c1, c2 color.RGBA) float64 {
-	dr := float64(c1.R) - float64(c2.R)
-	dg := float64(c1.G) - float64(c2.G)
-	db := float64(c1.B) - float64(c2.B)
+// stdioPath is the --image and --output path that stands for stdin and stdout
+const stdioPath = "-"
 
-	return dr*dr + dg*dg + db*db
-}
-
-// findNClosestColors finds the N closest colors in the given palette to the original color
-// It returns a slice of structs containing the distance and the color, sorted by distance
-func findNClosestColors(originalRGBA color.RGBA, paletteRGBAs []color.RGBA, n int) []struct {
-	dist  float64
-	color color.Color
-} {
-	if len(paletteRGBAs) == 0 {
-		return nil
+// displayPath returns the name of an input path used in log and error messages
+func displayPath(path string) string {
+	if path == stdioPath {
+		return "<stdin>"
 	}
-
-	distances := make([]struct {
-		dist  float64
-		color color.Color
-	}, 0, len(paletteRGBAs))
-
-	for _, pRGBA := range paletteRGBAs {
-		distances = append(distances, struct {
-			dist  float64
-			color color.Color
-		}{dist: colorDistanceSquared(originalRGBA, pRGBA), color: pRGBA})
-	}
-
-	sort.Slice(distances, func(i, j int) bool {
-		return distances[i].dist < distances[j].dist
-	})
-
-	if n > len(distances) {
-		n = len(distances)
-	}
-	return distances[:n]
+	return path
 }
 
-// blendColors takes a slice of colors and their corresponding weights and returns a single blended color
-func blendColors(colors []color.Color, weights []float64) color.RGBA {
-	if len(colors) == 0 || len(colors) != len(weights) {
-		return color.RGBA{}
-	}
-
-	var sumR, sumG, sumB float64
-	var totalWeight float64
-
-	for i := range colors {
-		rgba := toRGBA(colors[i])
-		sumR += float64(rgba.R) * weights[i]
-		sumG += float64(rgba.G) * weights[i]
-		sumB += float64(rgba.B) * weights[i]
-		totalWeight += weights[i]
-	}
-
-	if totalWeight == 0 {
-		return toRGBA(colors[0]) // Fallback to the first color if weights are somehow zero
+// readInput reads the encoded input image from a file, or from stdin for "-"
+// At most one byte more than recolor.MaxFileSize is read, recolor.Decode enforces the limit
+func readInput(imagePath string) ([]byte, error) {
+	file := os.Stdin
+	if imagePath != stdioPath {
+		var err error
+		file, err = os.Open(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open image file '%s': %v", imagePath, err)
+		}
+		defer file.Close()
 	}
 
-	return color.RGBA{
-		R: uint8(math.Round(sumR / totalWeight)),
-		G: uint8(math.Round(sumG / totalWeight)),
-		B: uint8(math.Round(sumB / totalWeight)),
-		A: 255,
+	data, err := io.ReadAll(io.LimitReader(file, recolor.MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image file '%s': %v", displayPath(imagePath), err)
 	}
+	return data, nil
 }
 
-// applyLuminosity adjusts a color's brightness by scaling its RGB components
-func applyLuminosity(c color.RGBA, factor float64) color.RGBA {
-	r := uint8(math.Max(0, math.Min(255, float64(c.R)*factor)))
-	g := uint8(math.Max(0, math.Min(255, float64(c.G)*factor)))
-	b := uint8(math.Max(0, math.Min(255, float64(c.B)*factor)))
-	return color.RGBA{R: r, G: g, B: b, A: c.A}
-}
-
-// shepardsMethodColor applies Shepard's Method for color interpolation
-// It finds the 'nearest' palette colors and blends them using inverse distance weighting
-func shepardsMethodColor(originalRGBA color.RGBA, paletteRGBAs []color.RGBA, nearest int, power float64) color.Color {
-	closest := findNClosestColors(originalRGBA, paletteRGBAs, nearest)
-	if len(closest) == 0 {
-		return originalRGBA // No palette colors available, return original color
-	}
-	// If an exact match is found or only one neighbor is requested, just return it
-	if len(closest) == 1 || closest[0].dist == 0 {
-		return closest[0].color
+// decodeAndValidateImage decodes and validates the image read from imagePath
+func decodeAndValidateImage(data []byte, imagePath string, themeAndFlavor string, luminosity float64, nearest int, power float64, autoOrient bool) (image.Image, recolor.Format, error) {
+	// Decode image, enforcing the size and dimension limits
+	img, format, err := recolor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot decode image '%s': %w", displayPath(imagePath), err)
 	}
 
-	weights := make([]float64, len(closest))
-	var totalWeight float64
-	for i, c := range closest {
-		if c.dist == 0 { // Avoid division by zero
-			return c.color
+	// Turn phone photos upright, image.Decode ignores the EXIF orientation
+	if autoOrient && format == recolor.FormatJPEG {
+		if orientation := exif.Orientation(exif.FromJPEG(data)); orientation != 1 {
+			img, err = recolor.EXIFOrientStage(orientation).Apply(context.Background(), img)
+			if err != nil {
+				return nil, "", err
+			}
+			log.Printf("Auto-oriented: EXIF orientation %d", orientation)
 		}
-		// Inverse distance weighting
-		weight := 1.0 / math.Pow(math.Sqrt(c.dist), power)
-		weights[i] = weight
-		totalWeight += weight
-	}
-
-	if totalWeight == 0 { // Fallback if all weights somehow sum to zero
-		return closest[0].color
 	}
 
-	return blendColors(extractColors(closest), weights)
-}
-
-// extractColors pulls just the color.Color from the sorted slice of (distance, color) tuples
-func extractColors(sortedColors []struct {
-	dist  float64
-	color color.Color
-}) []color.Color {
-	colors := make([]color.Color, len(sortedColors))
-	for i, item := range sortedColors {
-		colors[i] = item.color
+	// Validate theme, palettes from a provider are validated once they are generated
+	if !themes.IsProvider(themeAndFlavor) {
+		if _, err := themes.GetPalette(themeAndFlavor); err != nil {
+			return nil, "", fmt.Errorf("theme validation failed: %w", err)
+		}
 	}
-	return colors
-}
-
-// ProgressTracker tracks and displays processing progress
-type ProgressTracker struct {
-	total       int64
-	processed   int64
-	startTime   time.Time
-	lastUpdate  time.Time
-	updateMutex sync.Mutex
-}
 
-// NewProgressTracker creates a new progress tracker
-func NewProgressTracker(total int64) *ProgressTracker {
-	return &ProgressTracker{
-		total:      total,
-		startTime:  time.Now(),
-		lastUpdate: time.Now(),
+	// Validate parameters
+	if luminosity <= 0 {
+		return nil, "", fmt.Errorf("luminosity must be positive, got %.2f", luminosity)
 	}
-}
-
-// updateProgress increments the processed count and displays progress
-func (pt *ProgressTracker) updateProgress(increment int64) {
-	atomic.AddInt64(&pt.processed, increment)
-
-	pt.updateMutex.Lock()
-	defer pt.updateMutex.Unlock()
-
-	now := time.Now()
-	if now.Sub(pt.lastUpdate) < 100*time.Millisecond {
-		return
+	if nearest < 1 {
+		return nil, "", fmt.Errorf("nearest colors count must be at least 1, got %d", nearest)
 	}
-	pt.lastUpdate = now
-
-	processed := atomic.LoadInt64(&pt.processed)
-	if processed >= pt.total {
-		return
+	if power <= 0 {
+		return nil, "", fmt.Errorf("power must be positive, got %.2f", power)
 	}
 
-	percentage := float64(processed) / float64(pt.total) * 100
-	elapsed := now.Sub(pt.startTime)
-
-	if processed > 0 {
-		estimatedTotal := time.Duration(float64(elapsed) / float64(processed) * float64(pt.total))
-		remaining := estimatedTotal - elapsed
-
-		fmt.Printf("\rProgress: %.1f%% (%d/%d) Elapsed: %v ETA: %v",
-			percentage, processed, pt.total, elapsed.Round(time.Second), remaining.Round(time.Second))
-	}
+	return img, format, nil
 }
 
-// finishProgress completes the progress display
-func (pt *ProgressTracker) finishProgress() {
-	processed := atomic.LoadInt64(&pt.processed)
-	elapsed := time.Since(pt.startTime)
-	fmt.Printf("\rComplete: 100.0%% (%d/%d) in %v\n",
-		processed, pt.total, elapsed.Round(time.Millisecond))
+// resolvePalette returns the palette for a theme name
+// Names starting with "exec:" run an external provider, which is sent statistics about img
+func resolvePalette(ctx context.Context, themeAndFlavor string, img image.Image, format recolor.Format) (themes.Palette, error) {
+	if !themes.IsProvider(themeAndFlavor) {
+		return themes.GetPalette(themeAndFlavor)
+	}
+	return themes.RunProvider(ctx, themeAndFlavor, imageStats(img, format))
 }
 
-// processImageWithShepardsMethod applies Shepard's Method to each pixel of the image concurrently
-func processImageWithShepardsMethod(
-	img image.Image,
-	palette []color.Color,
-	luminosity float64,
-	nearest int,
-	power float64,
-) *image.RGBA {
+// imageStats summarizes img for a palette provider
+// Large images are sampled on a grid so this stays cheap
+func imageStats(img image.Image, format recolor.Format) *themes.ImageStats {
 	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	// Pre-convert palette colors to RGBA once
-	paletteRGBAs := make([]color.RGBA, len(palette))
-	for i, c := range palette {
-		paletteRGBAs[i] = toRGBA(c)
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/65536)))
+
+	var sumR, sumG, sumB, sumLum float64
+	var count float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sumR += float64(r >> 8)
+			sumG += float64(g >> 8)
+			sumB += float64(b >> 8)
+			sumLum += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			count++
+		}
 	}
-
-	// Initialize progress tracker
-	totalPixels := int64(width * height)
-	progress := NewProgressTracker(totalPixels)
-
-	// Determine number of workers based on CPU cores
-	numWorkers := runtime.NumCPU()
-	if numWorkers > height {
-		numWorkers = height // no more workers than rows
+	if count == 0 {
+		return &themes.ImageStats{Width: bounds.Dx(), Height: bounds.Dy(), Format: string(format)}
 	}
 
-	// Calculate rows per worker with ceiling division
-	rowsPerWorker := (height + numWorkers - 1) / numWorkers
-
-	// Prepare a slice to hold partial images from workers
-	partialImages := make([]*image.RGBA, numWorkers)
-
-	var wg sync.WaitGroup
-	for workerID := 0; workerID < numWorkers; workerID++ {
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
-
-			// Determine row range for this worker
-			startY := bounds.Min.Y + id*rowsPerWorker
-			endY := startY + rowsPerWorker
-			if endY > bounds.Max.Y {
-				endY = bounds.Max.Y
-			}
-
-			// Create partial image buffer for this worker
-			partialImg := image.NewRGBA(image.Rect(bounds.Min.X, startY, bounds.Max.X, endY))
-
-			pixelsProcessed := int64(0)
-			for y := startY; y < endY; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					originalColor := img.At(x, y)
-					originalRGBA := toRGBA(originalColor)
-
-					if originalRGBA.A == 0 {
-						partialImg.Set(x, y, color.Transparent)
-						continue
-					}
-
-					// Adjust luminosity and apply Shepard's method
-					adjustedColor := applyLuminosity(originalRGBA, luminosity)
-					finalColor := shepardsMethodColor(adjustedColor, paletteRGBAs, nearest, power)
-					partialImg.Set(x, y, finalColor)
-
-					pixelsProcessed++
-				}
-
-				// Update progress every 10 rows or at last row
-				if (y-startY)%10 == 0 || y == endY-1 {
-					progress.updateProgress(pixelsProcessed)
-					pixelsProcessed = 0
-				}
-			}
-			if pixelsProcessed > 0 {
-				progress.updateProgress(pixelsProcessed)
-			}
-
-			partialImages[id] = partialImg
-		}(workerID)
+	return &themes.ImageStats{
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		Format:    string(format),
+		Average:   fmt.Sprintf("#%02x%02x%02x", int(math.Round(sumR/count)), int(math.Round(sumG/count)), int(math.Round(sumB/count))),
+		Luminance: math.Round(sumLum/count*1000) / 1000,
 	}
+}
 
-	wg.Wait()
-	progress.finishProgress()
-
-	// Merge partial images into final image
-	newImg := image.NewRGBA(bounds)
-	for _, pImg := range partialImages {
-		if pImg == nil {
-			continue
-		}
-		for y := pImg.Bounds().Min.Y; y < pImg.Bounds().Max.Y; y++ {
-			for x := pImg.Bounds().Min.X; x < pImg.Bounds().Max.X; x++ {
-				newImg.Set(x, y, pImg.At(x, y))
-			}
+// getOutputFormat determines the output format based on input format and output path
+func getOutputFormat(inputFormat recolor.Format, outputPath string) recolor.Format {
+	if outputPath != "" && outputPath != stdioPath {
+		// If output path is specified, use its extension
+		if format, err := recolor.FormatFromPath(outputPath); err == nil {
+			return format
 		}
 	}
 
-	return newImg
+	// Preserve input format
+	return inputFormat
 }
 
-// decodeAndValidateImage opens, decodes, and validates the image.
-func decodeAndValidateImage(imagePath string, themeAndFlavor string, luminosity float64, nearest int, power float64) (image.Image, string, error) {
-	// Open file
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return nil, "", fmt.Errorf("cannot open image file '%s': %v", imagePath, err)
-	}
-	defer file.Close()
-
-	// Get file info
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, "", fmt.Errorf("cannot get file info for '%s': %v", imagePath, err)
-	}
-
-	// Check file size
-	if fileInfo.Size() > 100*1024*1024 {
-		return nil, "", fmt.Errorf("image file '%s' is too large (%.2f MB). Maximum size is 100 MB",
-			imagePath, float64(fileInfo.Size())/(1024*1024))
+// createTempFile creates a new file next to path for writing its replacement
+// Unlike os.CreateTemp, which uses mode 0600, the file gets mode 0666 less the
+// umask, the mode os.Create would give the output file itself
+func createTempFile(path string) (*os.File, error) {
+	for try := 0; ; try++ {
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.tmp", filepath.Base(path), rand.Uint32()))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if errors.Is(err, fs.ErrExist) && try < 100 {
+			continue
+		}
+		return f, err
 	}
+}
 
-	// Decode image
-	img, format, err := image.Decode(file)
+// saveFile writes an output file through write
+// The data is written to a temporary file next to outputPath and renamed into place,
+// so a failed or interrupted save never leaves a half-written output file behind.
+// An existing output file keeps its permissions.
+func saveFile(outputPath string, write func(w io.Writer) error) (err error) {
+	outFile, err := createTempFile(outputPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("cannot decode image '%s': %v. Must be a valid JPEG or PNG", imagePath, err)
-	}
-	if format != "jpeg" && format != "png" {
-		return nil, "", fmt.Errorf("unsupported image format '%s'. Only JPEG and PNG are supported", format)
-	}
-
-	// Check dimensions
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-	if width > MaxImageDimension || height > MaxImageDimension {
-		return nil, "", fmt.Errorf("image dimensions too large (%dx%d). Max is %d", width, height, MaxImageDimension)
-	}
-	if width*height > MaxImagePixels {
-		return nil, "", fmt.Errorf("image has too many pixels (%d). Max is %d", width*height, MaxImagePixels)
+		return fmt.Errorf("error creating output file '%s': %v", outputPath, err)
 	}
+	defer func() {
+		if err != nil {
+			outFile.Close()
+			os.Remove(outFile.Name())
+		}
+	}()
 
-	// Validate theme
-	if _, err := themes.GetPalette(themeAndFlavor); err != nil {
-		return nil, "", fmt.Errorf("theme validation failed: %v", err)
+	if err := write(outFile); err != nil {
+		return fmt.Errorf("error saving '%s': %w", outputPath, err)
 	}
-
-	// Validate parameters
-	if luminosity <= 0 {
-		return nil, "", fmt.Errorf("luminosity must be positive, got %.2f", luminosity)
+	if info, err := os.Stat(outputPath); err == nil {
+		if err := outFile.Chmod(info.Mode().Perm()); err != nil {
+			return fmt.Errorf("error setting permissions of output file '%s': %v", outputPath, err)
+		}
 	}
-	if nearest < 1 {
-		return nil, "", fmt.Errorf("nearest colors count must be at least 1, got %d", nearest)
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("error writing output file '%s': %v", outputPath, err)
 	}
-	if power <= 0 {
-		return nil, "", fmt.Errorf("power must be positive, got %.2f", power)
+	if err := os.Rename(outFile.Name(), outputPath); err != nil {
+		return fmt.Errorf("error moving output file into place at '%s': %v", outputPath, err)
 	}
 
-	return img, format, nil
+	return nil
 }
 
-// getOutputExtension determines the output file extension based on input format and output path
-func getOutputExtension(inputFormat string, outputPath string) string {
-	if outputPath != "" {
-		// If output path is specified, use its extension
-		ext := strings.ToLower(filepath.Ext(outputPath))
-		if ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
-			return ext
-		}
+// writeOutput writes the output image to a file, or to stdout for "-"
+func writeOutput(outputPath string, write func(w io.Writer) error) error {
+	if outputPath != stdioPath {
+		return saveFile(outputPath, write)
 	}
 
-	// Preserve input format
-	switch inputFormat {
-	case "jpeg":
-		return ".jpg"
-	case "png":
-		return ".png"
-	default:
-		return ".png" // Fallback
+	bw := bufio.NewWriter(os.Stdout)
+	if err := write(bw); err != nil {
+		return fmt.Errorf("error writing to stdout: %w", err)
 	}
-}
-
-// saveImage saves the processed image
-func saveImage(img image.Image, outputPath string, inputFormat string) error {
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("error creating output file '%s': %v", outputPath, err)
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("error writing to stdout: %v", err)
 	}
-	defer outFile.Close()
-
-	outputExt := getOutputExtension(inputFormat, outputPath)
+	return nil
+}
 
-	switch outputExt {
-	case ".jpg", ".jpeg":
-		var opt jpeg.Options
-		opt.Quality = 85
-		if err := jpeg.Encode(outFile, img, &opt); err != nil {
-			return fmt.Errorf("error encoding JPEG image to '%s': %v", outputPath, err)
-		}
-	case ".png":
-		if err := png.Encode(outFile, img); err != nil {
-			return fmt.Errorf("error encoding PNG image to '%s': %v", outputPath, err)
-		}
+// handleProcessingError reports a failed or cancelled run and exits
+func handleProcessingError(progress *ProgressTracker, err error, timeout time.Duration) {
+	progress.cancelProgress(err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Fatalf("Processing timed out after %v, no output written", timeout)
+	case errors.Is(err, context.Canceled):
+		log.Fatalf("Processing interrupted, no output written")
 	default:
-		return fmt.Errorf("unsupported output format '%s'. Use .png, .jpg, or .jpeg", outputExt)
+		log.Fatalf("Processing failed: %v", err)
 	}
-
-	return nil
 }
 
 // generateOutputPath creates the output path based on input path, theme and format
-func generateOutputPath(inputPath string, themeAndFlavor string, inputFormat string) string {
+func generateOutputPath(inputPath string, themeAndFlavor string, inputFormat recolor.Format) string {
 	dir := filepath.Dir(inputPath)
 	base := filepath.Base(inputPath)
 	nameWithoutExt := strings.TrimSuffix(base, filepath.Ext(base))
 
-	ext := getOutputExtension(inputFormat, "")
+	ext := getOutputFormat(inputFormat, "").Extension()
 
 	return filepath.Join(dir, fmt.Sprintf("%s_themed_%s%s",
 		nameWithoutExt, strings.ToLower(themeAndFlavor), ext))
@@ -498,7 +306,12 @@ func openFileInDefaultViewer(filePath string) {
 
 func main() {
 	log.SetFlags(0)
-	themes.ValidateThemeData() // Validate theme data at startup
+	// Stdout carries the image for -o -, so messages must never go there
+	log.SetOutput(os.Stderr)
+	// Validate theme data at startup
+	if err := themes.ValidateThemeData(); err != nil {
+		log.Fatalf("Invalid theme data: %v", err)
+	}
 
 	// --- Define variables for flags ---
 
@@ -508,13 +321,25 @@ func main() {
 	var luminosity float64
 	var nearest int
 	var power float64
+	var workers int
+	var timeout time.Duration
+	var progressMode string
+	var qualityName string
+	var recipePath string
 	var listThemesFlag bool
 	var showVersion bool
 	var open bool
+	var noAutoOrient bool
+	var keepMetadata bool
+	var profileName string
+	var jpegQuality int
+	var pngCompressionName string
+	var maxSizeName string
+	var formatName string
 
 	// --- Define and parse flags ---
 
-	flag.StringVar(&imagePath, "image", "", "Path to the input image (required). Supports JPEG, PNG")
+	flag.StringVar(&imagePath, "image", "", "Path to the input image (required). Supports JPEG, PNG, GIF, BMP, TIFF, PPM, PGM, PAM, WebP, QOI")
 	flag.StringVar(&imagePath, "i", "", "Shorthand for -image")
 
 	flag.StringVar(&themeAndFlavor, "theme", "", "Theme palette and optional flavor. Use -list-themes or -l to see all options.")
@@ -523,6 +348,8 @@ func main() {
 	flag.StringVar(&outputPath, "output", "", "Path for the output image (default: <input_filename>_themed_<theme-flavor>.<input_format>)")
 	flag.StringVar(&outputPath, "o", "", "Shorthand for -output")
 
+	flag.StringVar(&formatName, "format", "", "Output format, overriding the output path extension. Needed for -o - unless the input format is kept")
+
 	flag.BoolVar(&listThemesFlag, "list-themes", false, "List all available themes and their flavors")
 	flag.BoolVar(&listThemesFlag, "l", false, "Shorthand for -list-themes")
 
@@ -532,9 +359,27 @@ func main() {
 	flag.BoolVar(&open, "not-open", false, "not open the recolored image in the default viewer")
 
 	// Params specific to Shepard's Method
-	flag.Float64Var(&luminosity, "luminosity", defaultLuminosity, "Luminosity adjustment factor (e.g., 0.8 for darker, 1.2 for brighter)")
-	flag.IntVar(&nearest, "nearest", defaultNearest, "Number of nearest palette colors to consider for interpolation")
-	flag.Float64Var(&power, "power", defaultPower, "Power for Shepard's Method (influences how quickly weights fall off)")
+	flag.Float64Var(&luminosity, "luminosity", recolor.DefaultLuminosity, "Luminosity adjustment factor (e.g., 0.8 for darker, 1.2 for brighter)")
+	flag.IntVar(&nearest, "nearest", recolor.DefaultNearest, "Number of nearest palette colors to consider for interpolation")
+	flag.Float64Var(&power, "power", recolor.DefaultPower, "Power for Shepard's Method (influences how quickly weights fall off)")
+	flag.StringVar(&qualityName, "quality", string(recolor.QualityExact), "Processing quality: fast, balanced or exact")
+
+	// Resource limits
+	flag.IntVar(&workers, "workers", 0, "Number of worker goroutines (default: number of CPU cores)")
+	flag.DurationVar(&timeout, "timeout", 0, "Stop processing after this long, e.g. 30s (default: no limit)")
+
+	flag.BoolVar(&noAutoOrient, "no-auto-orient", false, "Do not rotate JPEG images according to their EXIF orientation")
+	flag.BoolVar(&keepMetadata, "keep-metadata", false, "Copy EXIF, XMP, ICC and PNG text metadata from the input to JPEG and PNG output")
+	flag.StringVar(&profileName, "color-profile", profileSRGB, "Handling of embedded ICC profiles: srgb, source or ignore")
+
+	// Encoder settings
+	flag.IntVar(&jpegQuality, "jpeg-quality", recolor.DefaultJPEGQuality, "JPEG output quality from 1 to 100")
+	flag.StringVar(&pngCompressionName, "png-compression", string(recolor.PNGCompressionDefault), "PNG output compression: none, fast, default or best")
+	flag.StringVar(&maxSizeName, "max-size", "", "Largest allowed output size, e.g. 500KB. JPEG quality is lowered to fit")
+
+	flag.StringVar(&recipePath, "recipe", "", "JSON recipe describing the processing stages")
+
+	flag.StringVar(&progressMode, "progress", "auto", "Progress output on stderr: auto, bar, plain, json or none")
 
 	flag.Usage = setUsage
 
@@ -560,49 +405,282 @@ func main() {
 		os.Exit(1)
 	}
 
+	// --- Load recipe, which may provide the theme ---
+	var recipe *recolor.Recipe
+	if recipePath != "" {
+		var err error
+		recipe, err = recolor.LoadRecipe(recipePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+			os.Exit(1)
+		}
+		if themeAndFlavor == "" {
+			themeAndFlavor = recipe.Theme
+		}
+	}
+
 	if themeAndFlavor == "" {
 		fmt.Fprintln(os.Stderr, "Error: -t or --theme <THEME-FLAVOR> is required.")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	// --- Decode and validate image ---
-	img, format, err := decodeAndValidateImage(imagePath, themeAndFlavor, luminosity, nearest, power)
+	var outputFormat recolor.Format
+	if formatName != "" {
+		var err error
+		outputFormat, err = recolor.ParseFormat(formatName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --format: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Reading stdin without an output path writes to stdout, as a pipeline expects
+	if imagePath == stdioPath && outputPath == "" {
+		outputPath = stdioPath
+	}
+
+	if workers < 0 {
+		fmt.Fprintf(os.Stderr, "Error: --workers must not be negative, got %d.\n", workers)
+		os.Exit(1)
+	}
+
+	quality, err := recolor.ParseQuality(qualityName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	profileMode, err := parseProfileMode(profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	if jpegQuality < 1 || jpegQuality > 100 {
+		fmt.Fprintf(os.Stderr, "Error: --jpeg-quality must be between 1 and 100, got %d.\n", jpegQuality)
+		os.Exit(1)
+	}
+	pngCompression, err := recolor.ParsePNGCompression(pngCompressionName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+	encodeOptions := recolor.EncodeOptions{JPEGQuality: jpegQuality, PNGCompression: pngCompression}
+
+	var maxSize int64
+	if maxSizeName != "" {
+		maxSize, err = parseByteSize(maxSizeName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max-size: %v.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	reporter, err := newProgressReporter(progressMode, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+		os.Exit(1)
+	}
+
+	// --- Set up cancellation on Ctrl-C, SIGTERM and --timeout ---
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// --- Read, decode and validate image ---
+	data, err := readInput(imagePath)
 	if err != nil {
 		log.Fatalf("Validation failed: %v", err)
 	}
+	img, format, err := decodeAndValidateImage(data, imagePath, themeAndFlavor, luminosity, nearest, power, !noAutoOrient)
+	if err != nil {
+		log.Fatalf("Validation failed: %v", err)
+	}
+
+	// --- Read metadata, which carries the color profile ---
+	var meta *metadata.Metadata
+	if keepMetadata || profileMode != profileIgnore {
+		meta, err = readMetadata(data, format)
+		if err != nil {
+			log.Printf("Metadata not read: %v", err)
+		}
+	}
+
+	// --- Convert to sRGB, the space theme palettes are defined in ---
+	var profile *icc.Profile
+	if profileMode != profileIgnore {
+		profile = inputProfile(meta)
+	}
+	if profile != nil {
+		img = profile.ToSRGB().Apply(img)
+		log.Printf("Color profile: converted '%s' to sRGB", profile.Description())
+	}
 
 	// --- Get palette ---
-	paletteColors, err := themes.GetPalette(themeAndFlavor)
+	palette, err := resolvePalette(ctx, themeAndFlavor, img, format)
 	if err != nil {
 		log.Fatalf("Error getting palette: %v", err)
 	}
-
-	// --- Load image ---
-	// (Already loaded above)
+	themeLabel := strings.ToLower(themeAndFlavor)
+	if themes.IsProvider(themeAndFlavor) {
+		themeLabel = palette.Key()
+	}
+
+	// --- Build pipeline ---
+	progress := NewProgressTracker(reporter)
+	options := recolor.Options{
+		Palette:    palette.Colors(),
+		Luminosity: luminosity,
+		Nearest:    nearest,
+		Power:      power,
+		Workers:    workers,
+		Quality:    quality,
+		OnProgress: progress.updateProgress,
+	}
+
+	// The output keeps the input format unless the recipe or the output path names another
+	defaultFormat := format
+	var pipeline *recolor.Pipeline
+	if recipe != nil {
+		pipeline, err = recipe.Pipeline(options)
+		if err != nil {
+			log.Fatalf("Invalid recipe: %v", err)
+		}
+		if recipe.Format != "" {
+			defaultFormat = recipe.Format
+		}
+	} else {
+		pipeline = recolor.NewPipeline(recolor.RecolorStage{Options: options})
+	}
+	pipeline.OnStageStart = progress.stageStart
+	pipeline.OnStageEnd = progress.stageEnd
 
 	// --- Process image with shepard's method ---
-	log.Printf("Theme: %s", strings.ToLower(themeAndFlavor))
-	log.Printf("Shepard's Method: nearest = %d, power = %.1f, luminosity = %.1f", nearest, power, luminosity)
-	log.Printf("Processing: '%s'", imagePath)
-
-	processedImg := processImageWithShepardsMethod(img, paletteColors, luminosity, nearest, power)
+	log.Printf("Theme: %s", themeLabel)
+	log.Printf("Shepard's Method: nearest = %d, power = %.1f, luminosity = %.1f, quality = %s", nearest, power, luminosity, quality)
+	if recipe != nil {
+		stageNames := make([]string, 0, len(pipeline.Stages()))
+		for _, stage := range pipeline.Stages() {
+			stageNames = append(stageNames, stage.Name())
+		}
+		log.Printf("Recipe: '%s' (%s)", recipePath, strings.Join(stageNames, " -> "))
+	}
+	log.Printf("Processing: '%s'", displayPath(imagePath))
 
 	// --- Determine output path ---
 	outPath := outputPath
 	if outPath == "" {
-		outPath = generateOutputPath(imagePath, themeAndFlavor, format)
+		outPath = generateOutputPath(imagePath, themeLabel, defaultFormat)
+	}
+	outFormat := getOutputFormat(defaultFormat, outPath)
+	if outputFormat != "" {
+		outFormat = outputFormat
+	}
+
+	// --- Recolor animations frame by frame, everything else through the pipeline ---
+	anim, err := decodeAnimation(data, imagePath, format)
+	if err != nil {
+		log.Fatalf("Validation failed: %v", err)
+	}
+	switch {
+	case anim == nil:
+	case anim.frames > 1 && recipe != nil:
+		log.Fatalf("Recipes cannot be applied to animations (%d frames)", anim.frames)
+	case anim.frames > 1 && outFormat != format:
+		log.Printf("Only the first of %d frames is kept in %s output", anim.frames, outFormat)
+		anim = nil
+	case recipe != nil || outFormat != format:
+		anim = nil
+	}
+	if anim != nil && profile != nil {
+		log.Printf("Color profile '%s' is not applied to animation frames", profile.Description())
+		profile = nil
+	}
+	if profile != nil && profileMode == profileSource && !canCarryMetadata(outFormat) {
+		log.Printf("Color profile: %s output cannot be tagged with '%s', writing sRGB", outFormat, profile.Description())
+		profileMode = profileSRGB
+	}
+
+	var write func(w io.Writer) error
+	if anim != nil {
+		progress.stageStart("recolor")
+		started := time.Now()
+		write, err = anim.recolor(ctx, options)
+		progress.stageEnd("recolor", time.Since(started))
+		if err != nil {
+			handleProcessingError(progress, err, timeout)
+		}
+	} else {
+		processed, err := pipeline.Run(ctx, img)
+		if err != nil {
+			handleProcessingError(progress, err, timeout)
+		}
+		if profile != nil && profileMode == profileSource {
+			processed = profile.FromSRGB().Apply(processed)
+		}
+		// encodeOptions is read on every call, so --max-size can change the quality
+		write = func(w io.Writer) error { return recolor.EncodeWithOptions(w, processed, outFormat, encodeOptions) }
+	}
+	progress.finishProgress()
+
+	// --- Carry metadata and the color profile over to the output ---
+	var outMeta *metadata.Metadata
+	switch {
+	case keepMetadata && meta != nil:
+		outMeta = meta
+		// The pixels were turned upright, so viewers must not rotate them again
+		if format == recolor.FormatJPEG && !noAutoOrient {
+			outMeta.ResetOrientation()
+		}
+		// The pixels are sRGB now, so the input profile no longer describes them
+		if profile != nil && profileMode == profileSRGB {
+			outMeta.ICC, outMeta.ICCName = nil, ""
+		}
+	case profile != nil && profileMode == profileSource:
+		outMeta = &metadata.Metadata{ICC: meta.ICC, ICCName: meta.ICCName}
+	}
+	switch {
+	case outMeta.IsEmpty():
+	case !canCarryMetadata(outFormat):
+		log.Printf("Metadata not kept: %s output cannot carry it", outFormat)
+	default:
+		write = withMetadata(write, outMeta, outFormat)
+	}
+
+	// --- Fit the output into --max-size ---
+	if maxSize > 0 {
+		quality := encodeOptions.JPEGQuality
+		data, err := fitOutput(write, &encodeOptions, outFormat, maxSize)
+		if err != nil {
+			log.Fatalf("Failed to fit output into --max-size: %v", err)
+		}
+		if encodeOptions.JPEGQuality != quality {
+			log.Printf("Lowered JPEG quality to %d to fit in %s (%s)", encodeOptions.JPEGQuality, formatByteSize(maxSize), formatByteSize(int64(len(data))))
+		}
+		write = func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}
 	}
 
 	// --- Save image ---
-	if err := saveImage(processedImg, outPath, format); err != nil {
+	if err := writeOutput(outPath, write); err != nil {
 		log.Fatalf("Failed to save image: %v", err)
 	}
 
-	log.Printf("Saved image: '%s'\n", outPath)
+	if outPath == stdioPath {
+		log.Printf("Wrote image to stdout")
+	} else {
+		log.Printf("Saved image: '%s'\n", outPath)
+	}
 
-	// --- Open output image in default viewer ---
-	if !open {
+	// --- Open output image in default viewer, unless it went to stdout ---
+	if !open && outPath != stdioPath {
 		log.Print(outPath)
 		openFileInDefaultViewer(outPath)
 	}
@@ -619,34 +697,113 @@ func setUsage() {
 	// Theme
 	fmt.Fprintf(w, "  %s--theme, -t <STRING>%s\n", bold, reset)
 	fmt.Fprintf(w, "\tTheme palette and optional flavor (required).\n")
-	fmt.Fprintf(w, "\tUse --list-themes to see all available themes and flavors.\n\n")
+	fmt.Fprintf(w, "\tUse --list-themes to see all available themes and flavors.\n")
+	fmt.Fprintf(w, "\t'exec:<PROGRAM> [THEME]' reads the palette from an external program.\n\n")
 
 	// Image
 	fmt.Fprintf(w, "  %s--image, -i <PATH>%s\n", bold, reset)
-	fmt.Fprintf(w, "\tPath to the input image (required). Supports JPEG, PNG formats.\n\n")
+	fmt.Fprintf(w, "\tPath to the input image (required). Supports JPEG, PNG, GIF, BMP, TIFF,\n")
+	fmt.Fprintf(w, "\tPPM, PGM, PAM, WebP and QOI formats, detected from the file content.\n")
+	fmt.Fprintf(w, "\tAnimated GIFs and PNGs keep all frames and their timing when saved in\n")
+	fmt.Fprintf(w, "\tthe same format. Use - to read the image from stdin.\n\n")
 
 	// Options heading
 	fmt.Fprintf(w, "%s%sOptions:%s\n\n", bold, underline, reset)
 
 	// Output
 	fmt.Fprintf(w, "  %s--output, -o <PATH>%s\n", bold, reset)
-	fmt.Fprintf(w, "\tPath for the output image.\n")
-	fmt.Fprintf(w, "\t(Default: <input_filename>_themed_<theme-flavor>.<input_format>)\n\n")
+	fmt.Fprintf(w, "\tPath for the output image. Its extension selects the output format.\n")
+	fmt.Fprintf(w, "\tUse - to write the image to stdout, logs always go to stderr.\n")
+	fmt.Fprintf(w, "\t(Default: <input_filename>_themed_<theme-flavor>.<input_format>,\n")
+	fmt.Fprintf(w, "\tor stdout when reading from stdin)\n\n")
+
+	// Format
+	fmt.Fprintf(w, "  %s--format <FORMAT>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tOutput format, e.g. png or jpg. Overrides the output path extension,\n")
+	fmt.Fprintf(w, "\tand selects the format written to stdout.\n")
+	fmt.Fprintf(w, "\t(Default: from the output path, or the input format)\n\n")
 
 	// Luminosity
 	fmt.Fprintf(w, "  %s--luminosity <FLOAT>%s\n", bold, reset)
 	fmt.Fprintf(w, "\tLuminosity adjustment factor (e.g., 0.8 for darker, 1.2 for brighter).\n")
-	fmt.Fprintf(w, "\t(Default: %.1f)\n\n", defaultLuminosity)
+	fmt.Fprintf(w, "\t(Default: %.1f)\n\n", recolor.DefaultLuminosity)
 
 	// Nearest
 	fmt.Fprintf(w, "  %s--nearest <COUNT>%s\n", bold, reset)
 	fmt.Fprintf(w, "\tNumber of nearest palette colors to consider for interpolation.\n")
-	fmt.Fprintf(w, "\t(Default: %d)\n\n", defaultNearest)
+	fmt.Fprintf(w, "\t(Default: %d)\n\n", recolor.DefaultNearest)
 
 	// Power
 	fmt.Fprintf(w, "  %s--power <FLOAT>%s\n", bold, reset)
 	fmt.Fprintf(w, "\tPower for Shepard's Method (influences how quickly weights fall off).\n")
-	fmt.Fprintf(w, "\t(Default: %.1f)\n\n", defaultPower)
+	fmt.Fprintf(w, "\t(Default: %.1f)\n\n", recolor.DefaultPower)
+
+	// Quality
+	fmt.Fprintf(w, "  %s--quality <LEVEL>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tProcessing quality: fast, balanced or exact.\n")
+	fmt.Fprintf(w, "\tfast and balanced map a downscaled proxy of large images and upsample\n")
+	fmt.Fprintf(w, "\tthe result with an edge-preserving filter, which is much quicker.\n")
+	fmt.Fprintf(w, "\t(Default: exact)\n\n")
+
+	// Workers
+	fmt.Fprintf(w, "  %s--workers <COUNT>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tNumber of worker goroutines used for processing.\n")
+	fmt.Fprintf(w, "\t(Default: number of CPU cores)\n\n")
+
+	// Timeout
+	fmt.Fprintf(w, "  %s--timeout <DURATION>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tStop processing after the given duration (e.g., 30s, 2m). No output is written.\n")
+	fmt.Fprintf(w, "\t(Default: no limit)\n\n")
+
+	// Progress
+	fmt.Fprintf(w, "  %s--progress <MODE>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tProgress output on stderr: auto, bar, plain, json or none.\n")
+	fmt.Fprintf(w, "\t'auto' shows a bar when stderr is a terminal and nothing otherwise.\n")
+	fmt.Fprintf(w, "\t'json' also reports stage_start and stage_end events with timings.\n")
+	fmt.Fprintf(w, "\tThe frames of an animation share one total. Each recolor stage of a\n")
+	fmt.Fprintf(w, "\trecipe restarts the count against its own total.\n")
+	fmt.Fprintf(w, "\t(Default: auto)\n\n")
+
+	// Recipe
+	fmt.Fprintf(w, "  %s--recipe <PATH>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tJSON recipe listing the stages to run: orient, resize, crop, tone,\n")
+	fmt.Fprintf(w, "\trecolor and dither. The recipe may set the theme, in which case\n")
+	fmt.Fprintf(w, "\t--theme is optional and overrides it when given.\n\n")
+
+	// Auto-orient
+	fmt.Fprintf(w, "  %s--no-auto-orient%s\n", bold, reset)
+	fmt.Fprintf(w, "\tKeep JPEG images as stored instead of rotating or mirroring them\n")
+	fmt.Fprintf(w, "\taccording to their EXIF orientation.\n\n")
+
+	// Metadata
+	fmt.Fprintf(w, "  %s--keep-metadata%s\n", bold, reset)
+	fmt.Fprintf(w, "\tCopy EXIF, XMP and ICC metadata from JPEG and PNG input, and PNG text\n")
+	fmt.Fprintf(w, "\tchunks, into JPEG or PNG output. The EXIF orientation is reset when the\n")
+	fmt.Fprintf(w, "\timage was auto-oriented.\n\n")
+
+	// JPEG quality
+	fmt.Fprintf(w, "  %s--jpeg-quality <1-100>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tQuality of JPEG output.\n")
+	fmt.Fprintf(w, "\t(Default: %d)\n\n", recolor.DefaultJPEGQuality)
+
+	// PNG compression
+	fmt.Fprintf(w, "  %s--png-compression <LEVEL>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tCompression of still PNG output: none, fast, default or best.\n")
+	fmt.Fprintf(w, "\t(Default: default)\n\n")
+
+	// Max size
+	fmt.Fprintf(w, "  %s--max-size <SIZE>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tLargest allowed output file, e.g. 500KB or 2MB (multiples of 1024).\n")
+	fmt.Fprintf(w, "\tJPEG output gets the highest quality up to --jpeg-quality that fits;\n")
+	fmt.Fprintf(w, "\tother formats fail when they are too large.\n\n")
+
+	// Color profile
+	fmt.Fprintf(w, "  %s--color-profile <MODE>%s\n", bold, reset)
+	fmt.Fprintf(w, "\tHandling of ICC profiles embedded in JPEG and PNG input, such as Display P3\n")
+	fmt.Fprintf(w, "\tor Adobe RGB. 'srgb' converts the image to sRGB before mapping and writes\n")
+	fmt.Fprintf(w, "\tsRGB. 'source' converts the result back and tags it with the input profile.\n")
+	fmt.Fprintf(w, "\t'ignore' treats the pixel values as sRGB.\n")
+	fmt.Fprintf(w, "\t(Default: srgb)\n\n")
 
 	// List Themes
 	fmt.Fprintf(w, "  %s--list-themes, -l%s\n", bold, reset)
@@ -666,7 +823,7 @@ func setUsage() {
 
 	// Memory Considerations
 	fmt.Fprintf(w, "%s%sMemory Note:%s\n\n", bold, underline, reset)
-	fmt.Fprintf(w, "  Processing large images (e.g., %dMP, ~%dx%d) can use significant RAM.\n", MaxImagePixels/1000000, int(math.Sqrt(float64(MaxImagePixels))), int(math.Sqrt(float64(MaxImagePixels))))
-	fmt.Fprintf(w, "  A %dMP image in RGBA format (4 bytes/pixel) may consume %sover 500 MiB of memory%s.\n", MaxImagePixels/1000000, bold, reset)
+	fmt.Fprintf(w, "  Processing large images (e.g., %dMP, ~%dx%d) can use significant RAM.\n", recolor.MaxImagePixels/1000000, int(math.Sqrt(float64(recolor.MaxImagePixels))), int(math.Sqrt(float64(recolor.MaxImagePixels))))
+	fmt.Fprintf(w, "  A %dMP image in RGBA format (4 bytes/pixel) may consume %sover 500 MiB of memory%s.\n", recolor.MaxImagePixels/1000000, bold, reset)
 	fmt.Fprintf(w, "  Ensure your system has enough free memory before running.\n\n")
 }
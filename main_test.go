@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs the tint command instead of the tests when TINT_TEST_MAIN is
+// set, so tests can run the command in a subprocess with its own arguments
+func TestMain(m *testing.M) {
+	if os.Getenv("TINT_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runTint runs the tint command with args and stdin, returning its stdout and stderr
+func runTint(t *testing.T, stdin []byte, args ...string) ([]byte, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "TINT_TEST_MAIN=1")
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("tint %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), stderr.String()
+}
+
+func TestSaveFilePermissions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(w io.Writer) error {
+		_, err := w.Write([]byte("image"))
+		return err
+	}
+
+	// A new output file gets the mode os.Create would give it
+	reference := filepath.Join(dir, "reference")
+	f, err := os.Create(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	want, err := os.Stat(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "new.png")
+	if err := saveFile(out, write); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Stat(out); err != nil || got.Mode() != want.Mode() {
+		t.Errorf("new output mode = %v, %v, want %v", got.Mode(), err, want.Mode())
+	}
+
+	// An existing output file keeps its mode
+	if err := os.Chmod(out, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(out)
+	if err := saveFile(out, write); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.Stat(out); err != nil || got.Mode() != before.Mode() {
+		t.Errorf("overwritten output mode = %v, %v, want %v", got.Mode(), err, before.Mode())
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("directory holds %d entries, want 2", len(entries))
+	}
+}
+
+func TestStdio(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 24, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 13)
+	}
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args           []string
+		prefix, suffix string
+	}{
+		{[]string{"-i", "-", "-t", "nord", "--progress", "plain"}, "\x89PNG\r\n\x1a\n", "IEND\xaeB`\x82"},
+		{[]string{"-i", "-", "-o", "-", "-t", "nord", "--format", "jpg"}, "\xff\xd8", "\xff\xd9"},
+	}
+	for _, tt := range tests {
+		stdout, stderr := runTint(t, input.Bytes(), tt.args...)
+
+		// Stdout holds exactly one image file, every message goes to stderr
+		if !bytes.HasPrefix(stdout, []byte(tt.prefix)) || !bytes.HasSuffix(stdout, []byte(tt.suffix)) {
+			t.Errorf("%v: stdout is not a single image file: %q...", tt.args, stdout[:min(len(stdout), 16)])
+		}
+		out, _, err := image.Decode(bytes.NewReader(stdout))
+		if err != nil || out.Bounds() != img.Bounds() {
+			t.Errorf("%v: decoding stdout: %v", tt.args, err)
+		}
+		if !strings.Contains(stderr, "Wrote image to stdout") {
+			t.Errorf("%v: stderr does not report the output:\n%s", tt.args, stderr)
+		}
+	}
+}
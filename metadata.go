@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/ashish0kumar/tint/formats/metadata"
+	"github.com/ashish0kumar/tint/recolor"
+)
+
+// readMetadata reads the metadata of an encoded JPEG or PNG input
+// It returns nil for other formats, which tint cannot read metadata from
+func readMetadata(data []byte, format recolor.Format) (*metadata.Metadata, error) {
+	if format != recolor.FormatJPEG && format != recolor.FormatPNG {
+		return nil, nil
+	}
+	if format == recolor.FormatJPEG {
+		return metadata.FromJPEG(data)
+	}
+	return metadata.FromPNG(data)
+}
+
+// canCarryMetadata reports whether metadata can be written to the format
+func canCarryMetadata(format recolor.Format) bool {
+	return format == recolor.FormatJPEG || format == recolor.FormatPNG
+}
+
+// withMetadata wraps write so that meta is inserted into the encoded JPEG or PNG
+func withMetadata(write func(w io.Writer) error, meta *metadata.Metadata, format recolor.Format) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+		if format == recolor.FormatJPEG {
+			return meta.WriteJPEG(w, buf.Bytes())
+		}
+		return meta.WritePNG(w, buf.Bytes())
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressReporter receives progress events from a ProgressTracker
+type ProgressReporter interface {
+	// Start is called once before processing begins
+	Start(total int64)
+	// Update is called periodically while processing
+	Update(processed, total int64, elapsed time.Duration)
+	// Finish is called once when processing completes
+	Finish(processed, total int64, elapsed time.Duration)
+	// Cancel is called instead of Finish when processing stops early
+	Cancel(processed, total int64, elapsed time.Duration, err error)
+}
+
+// StageReporter is implemented by reporters that also show pipeline stage timings
+type StageReporter interface {
+	// StageStart is called when a pipeline stage begins
+	StageStart(name string)
+	// StageEnd is called when a pipeline stage returns
+	StageEnd(name string, elapsed time.Duration)
+}
+
+// ProgressTracker adapts the library's progress callback to a ProgressReporter
+// It throttles updates to one every 100ms and keeps track of elapsed time
+type ProgressTracker struct {
+	total       int64
+	processed   int64
+	started     bool
+	startTime   time.Time
+	lastUpdate  time.Time
+	updateMutex sync.Mutex
+	reporter    ProgressReporter
+}
+
+// NewProgressTracker creates a new progress tracker
+// A nil reporter discards all progress events
+func NewProgressTracker(reporter ProgressReporter) *ProgressTracker {
+	if reporter == nil {
+		reporter = noProgress{}
+	}
+	return &ProgressTracker{
+		startTime:  time.Now(),
+		lastUpdate: time.Now(),
+		reporter:   reporter,
+	}
+}
+
+// updateProgress records the processed count and reports progress at most every 100ms
+// It matches the signature of recolor.Options.OnProgress
+func (pt *ProgressTracker) updateProgress(processed, total int64) {
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	// Each recolor stage of a recipe counts its own pixels, so the latest total applies
+	pt.start(total)
+	pt.total = total
+	pt.processed = processed
+
+	now := time.Now()
+	if now.Sub(pt.lastUpdate) < 100*time.Millisecond {
+		return
+	}
+	pt.lastUpdate = now
+
+	if processed >= pt.total {
+		return
+	}
+
+	pt.reporter.Update(processed, pt.total, now.Sub(pt.startTime))
+}
+
+// start reports the start of processing once, with the first total that is known
+func (pt *ProgressTracker) start(total int64) {
+	if pt.started {
+		return
+	}
+	pt.started = true
+	pt.total = total
+	pt.reporter.Start(total)
+}
+
+// finishProgress reports that processing has completed
+func (pt *ProgressTracker) finishProgress() {
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	pt.start(pt.total)
+	pt.reporter.Finish(pt.processed, pt.total, time.Since(pt.startTime))
+}
+
+// cancelProgress reports that processing stopped early because of err
+func (pt *ProgressTracker) cancelProgress(err error) {
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	pt.start(pt.total)
+	pt.reporter.Cancel(pt.processed, pt.total, time.Since(pt.startTime), err)
+}
+
+// stageStart forwards the start of a pipeline stage to the reporter
+// It matches the signature of recolor.Pipeline.OnStageStart
+func (pt *ProgressTracker) stageStart(name string) {
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	if sr, ok := pt.reporter.(StageReporter); ok {
+		sr.StageStart(name)
+	}
+}
+
+// stageEnd forwards the end of a pipeline stage to the reporter
+// It matches the signature of recolor.Pipeline.OnStageEnd
+func (pt *ProgressTracker) stageEnd(name string, elapsed time.Duration) {
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	if sr, ok := pt.reporter.(StageReporter); ok {
+		sr.StageEnd(name, elapsed)
+	}
+}
+
+// newProgressReporter returns the reporter for a --progress mode, writing to w
+// "auto" selects the bar when w is a terminal and no output otherwise
+func newProgressReporter(mode string, w *os.File) (ProgressReporter, error) {
+	switch strings.ToLower(mode) {
+	case "auto", "":
+		if isTerminal(w) {
+			return &barProgress{w: w}, nil
+		}
+		return noProgress{}, nil
+	case "bar":
+		return &barProgress{w: w}, nil
+	case "plain":
+		return &plainProgress{w: w, interval: 2 * time.Second}, nil
+	case "json":
+		return &jsonProgress{enc: json.NewEncoder(w)}, nil
+	case "none":
+		return noProgress{}, nil
+	default:
+		return nil, fmt.Errorf("invalid progress mode '%s'. Use auto, bar, plain, json or none", mode)
+	}
+}
+
+// isTerminal reports whether f is attached to a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// percentOf returns processed as a percentage of total
+func percentOf(processed, total int64) float64 {
+	if total <= 0 {
+		return 100
+	}
+	return float64(processed) / float64(total) * 100
+}
+
+// noProgress discards all progress events
+type noProgress struct{}
+
+func (noProgress) Start(int64)                               {}
+func (noProgress) Update(int64, int64, time.Duration)        {}
+func (noProgress) Finish(int64, int64, time.Duration)        {}
+func (noProgress) Cancel(int64, int64, time.Duration, error) {}
+
+// barProgress redraws a single progress bar line using carriage returns
+type barProgress struct {
+	w io.Writer
+}
+
+const progressBarWidth = 30
+
+func (b *barProgress) Start(int64) {}
+
+func (b *barProgress) Update(processed, total int64, elapsed time.Duration) {
+	if processed <= 0 {
+		return
+	}
+	estimatedTotal := time.Duration(float64(elapsed) / float64(processed) * float64(total))
+	remaining := estimatedTotal - elapsed
+
+	fmt.Fprintf(b.w, "\r%s %.1f%% (%d/%d) Elapsed: %v ETA: %v",
+		progressBar(processed, total), percentOf(processed, total), processed, total,
+		elapsed.Round(time.Second), remaining.Round(time.Second))
+}
+
+func (b *barProgress) Finish(processed, total int64, elapsed time.Duration) {
+	fmt.Fprintf(b.w, "\r%s 100.0%% (%d/%d) in %v\033[K\n",
+		progressBar(total, total), processed, total, elapsed.Round(time.Millisecond))
+}
+
+func (b *barProgress) Cancel(processed, total int64, elapsed time.Duration, err error) {
+	fmt.Fprintf(b.w, "\r%s %.1f%% (%d/%d) stopped after %v\033[K\n",
+		progressBar(processed, total), percentOf(processed, total), processed, total, elapsed.Round(time.Millisecond))
+}
+
+// progressBar renders a fixed-width bar such as "[#####-----]"
+func progressBar(processed, total int64) string {
+	filled := int(percentOf(processed, total) / 100 * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled) + "]"
+}
+
+// plainProgress prints a full line at a fixed interval, suitable for CI logs
+type plainProgress struct {
+	w        io.Writer
+	interval time.Duration
+	last     time.Duration
+}
+
+func (p *plainProgress) Start(total int64) {
+	fmt.Fprintf(p.w, "Progress: started (%d pixels)\n", total)
+}
+
+func (p *plainProgress) Update(processed, total int64, elapsed time.Duration) {
+	if elapsed-p.last < p.interval {
+		return
+	}
+	p.last = elapsed
+	fmt.Fprintf(p.w, "Progress: %.1f%% (%d/%d) Elapsed: %v\n",
+		percentOf(processed, total), processed, total, elapsed.Round(time.Second))
+}
+
+func (p *plainProgress) Finish(processed, total int64, elapsed time.Duration) {
+	fmt.Fprintf(p.w, "Complete: 100.0%% (%d/%d) in %v\n", processed, total, elapsed.Round(time.Millisecond))
+}
+
+func (p *plainProgress) Cancel(processed, total int64, elapsed time.Duration, err error) {
+	fmt.Fprintf(p.w, "Stopped: %.1f%% (%d/%d) after %v: %v\n",
+		percentOf(processed, total), processed, total, elapsed.Round(time.Millisecond), err)
+}
+
+// jsonProgress writes one JSON object per event, separated by newlines
+type jsonProgress struct {
+	enc *json.Encoder
+}
+
+// progressEvent is the JSON representation of a progress event
+type progressEvent struct {
+	Event     string  `json:"event"`
+	Processed int64   `json:"processed"`
+	Total     int64   `json:"total"`
+	Percent   float64 `json:"percent"`
+	ElapsedMS int64   `json:"elapsed_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// stageEvent is the JSON representation of a pipeline stage starting or ending
+type stageEvent struct {
+	Event     string `json:"event"`
+	Stage     string `json:"stage"`
+	ElapsedMS int64  `json:"elapsed_ms,omitempty"`
+}
+
+func (j *jsonProgress) Start(total int64) {
+	j.enc.Encode(progressEvent{Event: "start", Total: total})
+}
+
+func (j *jsonProgress) Update(processed, total int64, elapsed time.Duration) {
+	j.enc.Encode(progressEvent{Event: "progress", Processed: processed, Total: total,
+		Percent: percentOf(processed, total), ElapsedMS: elapsed.Milliseconds()})
+}
+
+func (j *jsonProgress) Finish(processed, total int64, elapsed time.Duration) {
+	j.enc.Encode(progressEvent{Event: "finish", Processed: processed, Total: total,
+		Percent: 100, ElapsedMS: elapsed.Milliseconds()})
+}
+
+func (j *jsonProgress) Cancel(processed, total int64, elapsed time.Duration, err error) {
+	j.enc.Encode(progressEvent{Event: "cancel", Processed: processed, Total: total,
+		Percent: percentOf(processed, total), ElapsedMS: elapsed.Milliseconds(), Error: err.Error()})
+}
+
+func (j *jsonProgress) StageStart(name string) {
+	j.enc.Encode(stageEvent{Event: "stage_start", Stage: name})
+}
+
+func (j *jsonProgress) StageEnd(name string, elapsed time.Duration) {
+	j.enc.Encode(stageEvent{Event: "stage_end", Stage: name, ElapsedMS: elapsed.Milliseconds()})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingProgress records the processed and total values of every update
+type recordingProgress struct {
+	noProgress
+	updates [][2]int64
+}
+
+func (r *recordingProgress) Update(processed, total int64, _ time.Duration) {
+	r.updates = append(r.updates, [2]int64{processed, total})
+}
+
+func TestProgressTrackerStageTotals(t *testing.T) {
+	// A recipe that recolors at full size, then again after shrinking the image
+	r := &recordingProgress{}
+	pt := NewProgressTracker(r)
+	for _, total := range []int64{1000, 100} {
+		pt.stageStart("recolor")
+		for done := int64(0); done < total; done += total / 4 {
+			pt.lastUpdate = time.Time{} // Skip the throttling
+			pt.updateProgress(done, total)
+		}
+		pt.stageEnd("recolor", 0)
+	}
+
+	if len(r.updates) != 8 {
+		t.Fatalf("got %d updates, want 8", len(r.updates))
+	}
+	for i, u := range r.updates {
+		want := int64(1000)
+		if i >= 4 {
+			want = 100
+		}
+		if u[1] != want || u[0] > u[1] {
+			t.Errorf("update %d = %d/%d, want a total of %d", i, u[0], u[1], want)
+		}
+	}
+}
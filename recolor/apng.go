@@ -0,0 +1,119 @@
+package recolor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/ashish0kumar/tint/formats/apng"
+)
+
+// IsAnimatedPNG reports whether data holds an animated PNG
+func IsAnimatedPNG(data []byte) bool {
+	return apng.IsAnimated(data)
+}
+
+// DecodeAPNG reads every frame of an animated PNG, keeping timing, offsets and blend and dispose operations
+// It applies the same size limits as Decode, counting the pixels of all frames together
+func DecodeAPNG(r io.Reader) (*apng.APNG, error) {
+	data, err := readInput(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAPNGData(data)
+}
+
+// decodeAPNGData decodes an animated PNG from data read by readInput
+func decodeAPNGData(data []byte) (*apng.APNG, error) {
+	config, err := apng.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode apng header: %w", err)
+	}
+	if err := checkDimensions(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+	if config.FramePixels > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %d frames with %d pixels in total exceed the maximum of %d",
+			ErrTooLarge, config.NumFrames, config.FramePixels, MaxImagePixels)
+	}
+
+	a, err := apng.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode apng image: %w", err)
+	}
+	return a, nil
+}
+
+// RecolorAPNG recolors every frame of an animated PNG with the same mapping
+// Frame timing, offsets and blend and dispose operations are kept. OnProgress
+// counts the pixels of all frames together.
+func RecolorAPNG(ctx context.Context, a *apng.APNG, opts Options) (*apng.APNG, error) {
+	m, err := NewMapper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalPixels int64
+	if a.Default != nil {
+		b := a.Default.Bounds()
+		totalPixels += int64(b.Dx() * b.Dy())
+	}
+	for _, f := range a.Frames {
+		b := f.Image.Bounds()
+		totalPixels += int64(b.Dx() * b.Dy())
+	}
+
+	return runStage(m.opts, "map", func() (*apng.APNG, error) {
+		// Each frame is mapped with the outer progress callback offset by the frames
+		// before it, and without the per-frame stage callbacks
+		var doneBefore int64
+		frameOpts := m.opts
+		frameOpts.OnStageStart, frameOpts.OnStageEnd = nil, nil
+		if m.opts.OnProgress != nil {
+			frameOpts.OnProgress = func(done, _ int64) {
+				m.opts.OnProgress(doneBefore+done, totalPixels)
+			}
+		}
+
+		fm := &Mapper{opts: frameOpts, paletteRGBAs: m.paletteRGBAs}
+		frameDone := func(pixels int64) {
+			doneBefore += pixels
+			if m.opts.OnProgress != nil {
+				m.opts.OnProgress(doneBefore, totalPixels)
+			}
+		}
+
+		out := *a
+		if a.Default != nil {
+			img, err := processImageWithQuality(ctx, a.Default, fm)
+			if err != nil {
+				return nil, err
+			}
+			out.Default = img
+			b := a.Default.Bounds()
+			frameDone(int64(b.Dx() * b.Dy()))
+		}
+
+		out.Frames = make([]apng.Frame, len(a.Frames))
+		for i, f := range a.Frames {
+			img, err := processImageWithQuality(ctx, f.Image, fm)
+			if err != nil {
+				return nil, err
+			}
+			out.Frames[i] = f
+			out.Frames[i].Image = img
+			b := f.Image.Bounds()
+			frameDone(int64(b.Dx() * b.Dy()))
+		}
+		return &out, nil
+	})
+}
+
+// EncodeAPNG writes every frame of a to w, or a still PNG when there is only one frame
+func EncodeAPNG(w io.Writer, a *apng.APNG) error {
+	if err := apng.Encode(w, a); err != nil {
+		return fmt.Errorf("cannot encode apng image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package recolor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // Registers the lossy and lossless WebP decoder
+
+	"github.com/ashish0kumar/tint/formats/pnm"
+	"github.com/ashish0kumar/tint/formats/qoi"
+	"github.com/ashish0kumar/tint/formats/webp"
+	"github.com/ashish0kumar/tint/themes"
+)
+
+// Limits applied when decoding untrusted input
+const (
+	MaxFileSize       = 100 * 1024 * 1024 // Maximum allowed size of an encoded image in bytes
+	MaxImageDimension = 10000             // Maximum allowed width or height of the image
+	MaxImagePixels    = 50000000          // Maximum allowed number of pixels in the image (~7071x7071)
+)
+
+var (
+	// ErrUnsupportedFormat is returned for input or output formats tint cannot handle
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned when an image exceeds MaxFileSize, MaxImageDimension or MaxImagePixels
+	ErrTooLarge = errors.New("image too large")
+	// ErrInvalidTheme is returned when a theme or flavor name cannot be resolved
+	ErrInvalidTheme = themes.ErrInvalidTheme
+)
+
+// DefaultJPEGQuality is the JPEG quality used when EncodeOptions.JPEGQuality is zero
+const DefaultJPEGQuality = 85
+
+// PNGCompression selects how hard the PNG encoder compresses
+type PNGCompression string
+
+const (
+	PNGCompressionNone    PNGCompression = "none"
+	PNGCompressionFast    PNGCompression = "fast"
+	PNGCompressionDefault PNGCompression = "default"
+	PNGCompressionBest    PNGCompression = "best"
+)
+
+// ParsePNGCompression converts a name such as "best" into a PNGCompression
+func ParsePNGCompression(s string) (PNGCompression, error) {
+	switch c := PNGCompression(strings.ToLower(strings.TrimSpace(s))); c {
+	case PNGCompressionNone, PNGCompressionFast, PNGCompressionDefault, PNGCompressionBest:
+		return c, nil
+	case "":
+		return PNGCompressionDefault, nil
+	default:
+		return "", fmt.Errorf("invalid PNG compression '%s'. Use none, fast, default or best", s)
+	}
+}
+
+// level returns the image/png compression level
+func (c PNGCompression) level() png.CompressionLevel {
+	switch c {
+	case PNGCompressionNone:
+		return png.NoCompression
+	case PNGCompressionFast:
+		return png.BestSpeed
+	case PNGCompressionBest:
+		return png.BestCompression
+	default:
+		return png.DefaultCompression
+	}
+}
+
+// EncodeOptions configures the encoders used by EncodeWithOptions
+// Zero values select the defaults, and formats ignore the options that do not apply to them.
+type EncodeOptions struct {
+	// JPEGQuality is the JPEG quality from 1 to 100 (default DefaultJPEGQuality)
+	JPEGQuality int
+	// PNGCompression is the PNG compression level (default PNGCompressionDefault)
+	PNGCompression PNGCompression
+}
+
+// Format is the name of an image format, as registered with image.RegisterFormat
+type Format string
+
+// Supported image formats
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+	FormatBMP  Format = "bmp"
+	FormatTIFF Format = "tiff"
+	FormatPPM  Format = "ppm"
+	FormatPGM  Format = "pgm"
+	FormatPAM  Format = "pam"
+	FormatWebP Format = "webp"
+	FormatQOI  Format = "qoi"
+)
+
+// formatInfo describes how to encode a supported format
+type formatInfo struct {
+	extensions []string // File extensions, the first one is used for generated paths
+	encode     func(w io.Writer, img image.Image, opts EncodeOptions) error
+}
+
+// withoutOptions adapts an encoder that has no options
+func withoutOptions(encode func(w io.Writer, img image.Image) error) func(io.Writer, image.Image, EncodeOptions) error {
+	return func(w io.Writer, img image.Image, _ EncodeOptions) error {
+		return encode(w, img)
+	}
+}
+
+var formats = map[Format]formatInfo{
+	FormatJPEG: {
+		extensions: []string{".jpg", ".jpeg"},
+		encode: func(w io.Writer, img image.Image, opts EncodeOptions) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.JPEGQuality})
+		},
+	},
+	FormatPNG: {
+		extensions: []string{".png"},
+		encode: func(w io.Writer, img image.Image, opts EncodeOptions) error {
+			encoder := png.Encoder{CompressionLevel: opts.PNGCompression.level()}
+			return encoder.Encode(w, img)
+		},
+	},
+	FormatGIF: {
+		extensions: []string{".gif"},
+		encode:     withoutOptions(encodeGIF),
+	},
+	FormatBMP: {
+		extensions: []string{".bmp"},
+		encode:     withoutOptions(bmp.Encode),
+	},
+	FormatTIFF: {
+		extensions: []string{".tiff", ".tif"},
+		encode: withoutOptions(func(w io.Writer, img image.Image) error {
+			return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+		}),
+	},
+	FormatPPM: {
+		extensions: []string{".ppm"},
+		encode:     withoutOptions(pnm.EncodePPM),
+	},
+	FormatPGM: {
+		extensions: []string{".pgm"},
+		encode:     withoutOptions(pnm.EncodePGM),
+	},
+	FormatPAM: {
+		extensions: []string{".pam"},
+		encode:     withoutOptions(pnm.EncodePAM),
+	},
+	FormatWebP: {
+		extensions: []string{".webp"},
+		encode:     withoutOptions(webp.Encode), // Always lossless
+	},
+	FormatQOI: {
+		extensions: []string{".qoi"},
+		encode:     withoutOptions(qoi.Encode),
+	},
+}
+
+// ParseFormat converts a format name such as "png" or "jpg" into a Format
+func ParseFormat(name string) (Format, error) {
+	cleaned := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), ".")
+	for format, info := range formats {
+		if cleaned == string(format) {
+			return format, nil
+		}
+		for _, ext := range info.extensions {
+			if "."+cleaned == ext {
+				return format, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w '%s'", ErrUnsupportedFormat, name)
+}
+
+// FormatFromPath returns the format implied by the extension of path
+func FormatFromPath(path string) (Format, error) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return "", fmt.Errorf("%w: '%s' has no file extension", ErrUnsupportedFormat, path)
+	}
+	return ParseFormat(ext)
+}
+
+// Extension returns the preferred file extension for the format, including the dot
+func (f Format) Extension() string {
+	if info, ok := formats[f]; ok {
+		return info.extensions[0]
+	}
+	return ""
+}
+
+// checkDimensions checks image dimensions against MaxImageDimension and MaxImagePixels
+func checkDimensions(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("image has invalid dimensions (%dx%d)", width, height)
+	}
+	if width > MaxImageDimension || height > MaxImageDimension {
+		return fmt.Errorf("%w: dimensions %dx%d exceed the maximum of %d", ErrTooLarge, width, height, MaxImageDimension)
+	}
+	if width*height > MaxImagePixels {
+		return fmt.Errorf("%w: %d pixels exceed the maximum of %d", ErrTooLarge, width*height, MaxImagePixels)
+	}
+	return nil
+}
+
+// Decode reads an image from r and detects its format from the content
+// It reads at most MaxFileSize bytes, and checks the dimensions declared in the
+// header before decoding, so oversized images are rejected before any pixel
+// memory is allocated
+func Decode(r io.Reader) (image.Image, Format, error) {
+	data, err := readInput(r)
+	if err != nil {
+		return nil, "", err
+	}
+	return decodeData(data)
+}
+
+// readInput reads all of r, failing with ErrTooLarge beyond MaxFileSize bytes
+func readInput(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image: %w", err)
+	}
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("%w: input exceeds the maximum size of %d MB", ErrTooLarge, MaxFileSize/(1024*1024))
+	}
+	return data, nil
+}
+
+// isTIFF reports whether data starts with a little or big endian TIFF header
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// decodeConfigData decodes the header of an image read by readInput
+// TIFF is decoded from a bytes.Reader directly: image.DecodeConfig wraps the
+// reader in a bufio.Reader, and without an io.ReaderAt the tiff package buffers
+// everything up to the offsets in the header, allocating gigabytes for a small file.
+func decodeConfigData(data []byte) (image.Config, string, error) {
+	if isTIFF(data) {
+		config, err := tiff.DecodeConfig(bytes.NewReader(data))
+		return config, string(FormatTIFF), err
+	}
+	return image.DecodeConfig(bytes.NewReader(data))
+}
+
+// decodeImageData decodes an image read by readInput, see decodeConfigData
+func decodeImageData(data []byte) (image.Image, error) {
+	if isTIFF(data) {
+		return tiff.Decode(bytes.NewReader(data))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// decodeData decodes an image read by readInput, checking its dimensions first
+func decodeData(data []byte) (image.Image, Format, error) {
+	// Decode the header only, so that a small file declaring huge dimensions
+	// is rejected before any pixel memory is allocated
+	config, name, err := decodeConfigData(data)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", fmt.Errorf("%w: content is not a recognized image", ErrUnsupportedFormat)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot decode image header: %w", err)
+	}
+	format := Format(name)
+	if _, ok := formats[format]; !ok {
+		return nil, "", fmt.Errorf("%w '%s'", ErrUnsupportedFormat, name)
+	}
+	if err := checkDimensions(config.Width, config.Height); err != nil {
+		return nil, "", err
+	}
+
+	img, err := decodeImageData(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot decode %s image: %w", format, err)
+	}
+
+	// Check the decoded bounds as well, in case they disagree with the header
+	bounds := img.Bounds()
+	if err := checkDimensions(bounds.Dx(), bounds.Dy()); err != nil {
+		return nil, "", err
+	}
+
+	return img, format, nil
+}
+
+// Encode writes img to w in the given format with the default encoder options
+func Encode(w io.Writer, img image.Image, format Format) error {
+	return EncodeWithOptions(w, img, format, EncodeOptions{})
+}
+
+// EncodeWithOptions writes img to w in the given format
+func EncodeWithOptions(w io.Writer, img image.Image, format Format, opts EncodeOptions) error {
+	info, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("%w '%s'", ErrUnsupportedFormat, format)
+	}
+	if opts.JPEGQuality == 0 {
+		opts.JPEGQuality = DefaultJPEGQuality
+	}
+	if opts.JPEGQuality < 1 || opts.JPEGQuality > 100 {
+		return fmt.Errorf("JPEG quality must be between 1 and 100, got %d", opts.JPEGQuality)
+	}
+	if _, err := ParsePNGCompression(string(opts.PNGCompression)); err != nil {
+		return err
+	}
+	if err := info.encode(w, img, opts); err != nil {
+		return fmt.Errorf("cannot encode %s image: %w", format, err)
+	}
+	return nil
+}
+
+// RecolorStream decodes an image from r, recolors it and encodes the result to w
+// If format is empty the input format is kept. It returns the format that was written.
+// A GIF written as a GIF or an animated PNG written as a PNG keeps all of its frames,
+// see RecolorGIF and RecolorAPNG.
+func RecolorStream(ctx context.Context, r io.Reader, w io.Writer, format Format, opts Options) (Format, error) {
+	if format != "" {
+		if _, ok := formats[format]; !ok {
+			return "", fmt.Errorf("%w '%s'", ErrUnsupportedFormat, format)
+		}
+	}
+
+	data, err := readInput(r)
+	if err != nil {
+		return "", err
+	}
+
+	if _, name, err := image.DecodeConfig(bytes.NewReader(data)); err == nil &&
+		Format(name) == FormatGIF && (format == "" || format == FormatGIF) {
+		g, err := decodeGIFData(data)
+		if err != nil {
+			return "", err
+		}
+		out, err := RecolorGIF(ctx, g, opts)
+		if err != nil {
+			return "", err
+		}
+		return FormatGIF, EncodeGIF(w, out)
+	}
+
+	if (format == "" || format == FormatPNG) && IsAnimatedPNG(data) {
+		a, err := decodeAPNGData(data)
+		if err != nil {
+			return "", err
+		}
+		out, err := RecolorAPNG(ctx, a, opts)
+		if err != nil {
+			return "", err
+		}
+		return FormatPNG, EncodeAPNG(w, out)
+	}
+
+	img, inputFormat, err := decodeData(data)
+	if err != nil {
+		return "", err
+	}
+	if format == "" {
+		format = inputFormat
+	}
+
+	out, err := Recolor(ctx, img, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := Encode(w, out, format); err != nil {
+		return "", err
+	}
+	return format, nil
+}
@@ -0,0 +1,197 @@
+package recolor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"runtime"
+	"testing"
+)
+
+// pngChunk returns a PNG chunk with its length and CRC
+func pngChunk(typ string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(typ)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	return b.Bytes()
+}
+
+// pngHeader returns a PNG signature and an IHDR chunk declaring an 8-bit RGBA image
+func pngHeader(width, height uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	return append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr)...)
+}
+
+// oversizedAPNG returns an animated PNG whose frames fit one by one but not together
+func oversizedAPNG(width, height uint32, frames int) []byte {
+	data := pngHeader(width, height)
+	data = append(data, pngChunk("acTL", binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(frames)), 0))...)
+	for i := 0; i < frames; i++ {
+		fctl := binary.BigEndian.AppendUint32(nil, uint32(i))
+		fctl = binary.BigEndian.AppendUint32(fctl, width)
+		fctl = binary.BigEndian.AppendUint32(fctl, height)
+		fctl = append(fctl, make([]byte, 8)...) // x and y offset
+		fctl = append(fctl, 0, 1, 0, 1, 0, 0)   // delay, dispose and blend
+		data = append(data, pngChunk("fcTL", fctl)...)
+	}
+	return append(data, pngChunk("IEND", nil)...)
+}
+
+// oversizedGIF returns a GIF with frames of width x height and almost no pixel data
+func oversizedGIF(width, height uint16, frames int) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	data = append(data, 0x80, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff)
+	for i := 0; i < frames; i++ {
+		data = append(data, 0x2c, 0, 0, 0, 0)
+		data = binary.LittleEndian.AppendUint16(data, width)
+		data = binary.LittleEndian.AppendUint16(data, height)
+		data = append(data, 0, 2, 2, 0x4c, 0x01, 0)
+	}
+	return append(data, 0x3b)
+}
+
+// bmpHeader returns the headers of a 24-bit BMP of width x height
+func bmpHeader(width, height int32) []byte {
+	data := []byte("BM")
+	data = binary.LittleEndian.AppendUint32(data, 54)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 54)
+	data = binary.LittleEndian.AppendUint32(data, 40)
+	data = binary.LittleEndian.AppendUint32(data, uint32(width))
+	data = binary.LittleEndian.AppendUint32(data, uint32(height))
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint16(data, 24)
+	return append(data, make([]byte, 24)...)
+}
+
+// encodedImage returns a small image encoded in format
+func encodedImage(t testing.TB, format Format) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 7, 5))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37)
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, img, format); err != nil {
+		t.Fatalf("Encode(%s): %v", format, err)
+	}
+	return b.Bytes()
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"png width", pngHeader(MaxImageDimension+1, 1)},
+		{"png pixels", pngHeader(MaxImageDimension, MaxImageDimension)},
+		{"gif screen", oversizedGIF(MaxImageDimension+1, 1, 1)},
+		{"bmp pixels", bmpHeader(MaxImageDimension, MaxImageDimension)},
+		{"bmp top-down", bmpHeader(MaxImageDimension, -MaxImageDimension)},
+	}
+	for _, tt := range tests {
+		if _, _, err := Decode(bytes.NewReader(tt.data)); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: Decode error = %v, want ErrTooLarge", tt.name, err)
+		}
+	}
+
+	// Each frame fits, but all frames together exceed MaxImagePixels
+	if _, err := DecodeGIF(bytes.NewReader(oversizedGIF(5000, 5000, 40))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("DecodeGIF error = %v, want ErrTooLarge", err)
+	}
+	if _, err := DecodeAPNG(bytes.NewReader(oversizedAPNG(5000, 5000, 40))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("DecodeAPNG error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestDecodeTIFFFarOffset(t *testing.T) {
+	// The header points its first IFD 2 GB into an 8 byte file
+	data := []byte("II*\x00\x00\x00\x00\x78")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("Decode succeeded")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("Decode allocated %d bytes for an 8 byte file", allocated)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for format := range formats {
+		data := encodedImage(t, format)
+		if _, got, err := Decode(bytes.NewReader(data)); err != nil || got != format {
+			t.Errorf("Decode(%s) = %s, %v", format, got, err)
+		}
+		for _, n := range []int{0, 1, len(data) / 4, len(data) / 2} {
+			if _, _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+				t.Errorf("Decode(%s truncated to %d of %d bytes) succeeded", format, n, len(data))
+			}
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for format := range formats {
+		f.Add(encodedImage(f, format))
+	}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White}),
+			image.NewPaletted(image.Rect(1, 1, 3, 3), color.Palette{color.Black, color.White}),
+		},
+		Delay: []int{10, 10},
+	}
+	var b bytes.Buffer
+	if err := gif.EncodeAll(&b, g); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b.Bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Whatever Decode accepts must be within the limits, and headers that
+		// declare more than the limits must fail with ErrTooLarge
+		img, _, err := Decode(bytes.NewReader(data))
+		if err == nil {
+			bounds := img.Bounds()
+			if checkDimensions(bounds.Dx(), bounds.Dy()) != nil {
+				t.Fatalf("Decode returned a %dx%d image", bounds.Dx(), bounds.Dy())
+			}
+		}
+		if config, _, cerr := decodeConfigData(data); cerr == nil {
+			if errors.Is(checkDimensions(config.Width, config.Height), ErrTooLarge) && !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("header declares %dx%d, Decode error = %v, want ErrTooLarge", config.Width, config.Height, err)
+			}
+		}
+
+		// The animation decoders check all frames together
+		if g, err := DecodeGIF(bytes.NewReader(data)); err == nil {
+			var pixels int
+			for _, frame := range g.Image {
+				pixels += frame.Bounds().Dx() * frame.Bounds().Dy()
+			}
+			if pixels > MaxImagePixels {
+				t.Fatalf("DecodeGIF returned %d frames with %d pixels", len(g.Image), pixels)
+			}
+		}
+		if a, err := DecodeAPNG(bytes.NewReader(data)); err == nil {
+			var pixels int
+			for _, frame := range a.Frames {
+				pixels += frame.Image.Bounds().Dx() * frame.Image.Bounds().Dy()
+			}
+			if pixels > MaxImagePixels {
+				t.Fatalf("DecodeAPNG returned %d frames with %d pixels", len(a.Frames), pixels)
+			}
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package recolor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"sort"
+)
+
+// DecodeGIF reads every frame of a GIF, keeping delays, disposal methods and the loop count
+// It applies the same size limits as Decode, counting the pixels of all frames together
+func DecodeGIF(r io.Reader) (*gif.GIF, error) {
+	data, err := readInput(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGIFData(data)
+}
+
+// decodeGIFData decodes an animated or still GIF from data read by readInput
+func decodeGIFData(data []byte) (*gif.GIF, error) {
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode gif header: %w", err)
+	}
+	if err := checkDimensions(config.Width, config.Height); err != nil {
+		return nil, err
+	}
+
+	// A small GIF can declare many huge frames, so count them before decoding any
+	frames, totalPixels := gifFramePixels(data)
+	if totalPixels > MaxImagePixels {
+		return nil, fmt.Errorf("%w: %d frames with %d pixels in total exceed the maximum of %d",
+			ErrTooLarge, frames, totalPixels, MaxImagePixels)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode gif image: %w", err)
+	}
+	return g, nil
+}
+
+// gifFramePixels walks the blocks of a GIF and returns its frame count and the
+// pixels of all frames together, as declared by their image descriptors
+// It stops at the first malformed block, leaving the error to gif.DecodeAll.
+func gifFramePixels(data []byte) (frames, pixels int) {
+	// skipSubBlocks returns the offset after the data sub-blocks starting at i
+	skipSubBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+	// colorTableSize returns the size of the color table announced by a packed field
+	colorTableSize := func(packed byte) int {
+		if packed&0x80 == 0 {
+			return 0
+		}
+		return 3 << (packed&0x07 + 1)
+	}
+
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0
+	}
+	i := 13 + colorTableSize(data[10])
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: label, then sub-blocks
+			i = skipSubBlocks(i + 2)
+		case 0x2c: // Image descriptor
+			if i+10 > len(data) {
+				return frames, pixels
+			}
+			width := int(data[i+5]) | int(data[i+6])<<8
+			height := int(data[i+7]) | int(data[i+8])<<8
+			frames++
+			pixels += width * height
+			// Skip the local color table and the LZW minimum code size
+			i = skipSubBlocks(i + 10 + colorTableSize(data[i+9]) + 1)
+		default: // Trailer or a malformed block
+			return frames, pixels
+		}
+	}
+	return frames, pixels
+}
+
+// RecolorGIF recolors every frame of a GIF and returns a new GIF with the same timing
+// Shepard's Method maps each color independently, so the frames keep their pixels and
+// only their palettes are mapped. This gives exactly the colors Recolor would produce,
+// keeps the transparent index of every frame and ignores Options.Quality.
+func RecolorGIF(ctx context.Context, g *gif.GIF, opts Options) (*gif.GIF, error) {
+	m, err := NewMapper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalPixels int64
+	for _, frame := range g.Image {
+		bounds := frame.Bounds()
+		totalPixels += int64(bounds.Dx() * bounds.Dy())
+	}
+
+	return runStage(m.opts, "map", func() (*gif.GIF, error) {
+		progress := newProgressTracker(totalPixels, m.opts.OnProgress)
+		out := *g
+		out.Image = make([]*image.Paletted, len(g.Image))
+		out.Delay = append([]int(nil), g.Delay...)
+		out.Disposal = append([]byte(nil), g.Disposal...)
+
+		// Identical palettes map to identical results, so the global color
+		// table keeps matching the frames that use it
+		if global, ok := g.Config.ColorModel.(color.Palette); ok {
+			out.Config.ColorModel = mapPalette(m, global)
+		}
+
+		for i, frame := range g.Image {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			out.Image[i] = &image.Paletted{
+				Pix:     append([]uint8(nil), frame.Pix...),
+				Stride:  frame.Stride,
+				Rect:    frame.Rect,
+				Palette: mapPalette(m, frame.Palette),
+			}
+			bounds := frame.Bounds()
+			progress.updateProgress(int64(bounds.Dx() * bounds.Dy()))
+		}
+		return &out, nil
+	})
+}
+
+// mapPalette maps each palette entry with m, transparent entries stay transparent
+func mapPalette(m *Mapper, palette color.Palette) color.Palette {
+	mapped := make(color.Palette, len(palette))
+	for i, c := range palette {
+		mapped[i] = m.Convert(c)
+	}
+	return mapped
+}
+
+// EncodeGIF writes every frame of g to w
+func EncodeGIF(w io.Writer, g *gif.GIF) error {
+	if err := gif.EncodeAll(w, g); err != nil {
+		return fmt.Errorf("cannot encode gif image: %w", err)
+	}
+	return nil
+}
+
+// encodeGIF writes a still image as a GIF, reducing it to at most 256 colors
+func encodeGIF(w io.Writer, img image.Image) error {
+	return gif.Encode(w, quantize(img), nil)
+}
+
+// quantize reduces img to a paletted image of at most 256 colors
+// Images that already fit keep their exact colors. Otherwise colors are grouped into
+// buckets of 5 bits per channel and the most common buckets become the palette.
+// The result is not dithered, so flat areas stay flat.
+func quantize(img image.Image) *image.Paletted {
+	if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= 256 {
+		return p
+	}
+
+	bounds := img.Bounds()
+	counts := make(map[color.RGBA]int)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			counts[rgbaAt(img, x, y)]++
+		}
+	}
+
+	var palette color.Palette
+	if len(counts) <= 256 {
+		palette = make(color.Palette, 0, len(counts))
+		for c := range counts {
+			palette = append(palette, c)
+		}
+	} else {
+		palette = popularPalette(counts, 256)
+	}
+
+	// Sort so that the output does not depend on map iteration order
+	sort.Slice(palette, func(i, j int) bool {
+		return rgbaKey(palette[i].(color.RGBA)) < rgbaKey(palette[j].(color.RGBA))
+	})
+
+	dst := image.NewPaletted(bounds, palette)
+	cache := make(map[color.RGBA]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := rgbaAt(img, x, y)
+			index, ok := cache[c]
+			if !ok {
+				index = uint8(palette.Index(c))
+				cache[c] = index
+			}
+			dst.Pix[dst.PixOffset(x, y)] = index
+		}
+	}
+	return dst
+}
+
+// popularPalette returns the average colors of the n most common 5-bit buckets
+// One entry is reserved for full transparency when any pixel is transparent
+func popularPalette(counts map[color.RGBA]int, n int) color.Palette {
+	type bucket struct {
+		key        uint32
+		count      int
+		r, g, b, a int
+	}
+	buckets := make(map[uint32]*bucket)
+	transparent := false
+	for c, count := range counts {
+		if c.A == 0 {
+			transparent = true
+			continue
+		}
+		key := uint32(c.R>>3)<<15 | uint32(c.G>>3)<<10 | uint32(c.B>>3)<<5 | uint32(c.A>>3)
+		bk := buckets[key]
+		if bk == nil {
+			bk = &bucket{key: key}
+			buckets[key] = bk
+		}
+		bk.count += count
+		bk.r += int(c.R) * count
+		bk.g += int(c.G) * count
+		bk.b += int(c.B) * count
+		bk.a += int(c.A) * count
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	palette := make(color.Palette, 0, n)
+	if transparent {
+		palette = append(palette, color.RGBA{})
+	}
+	for _, bk := range sorted {
+		if len(palette) == n {
+			break
+		}
+		palette = append(palette, color.RGBA{
+			R: uint8(bk.r / bk.count),
+			G: uint8(bk.g / bk.count),
+			B: uint8(bk.b / bk.count),
+			A: uint8(bk.a / bk.count),
+		})
+	}
+	return palette
+}
+
+// rgbaKey packs c into a single sortable value
+func rgbaKey(c color.RGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
@@ -0,0 +1,116 @@
+package recolor
+
+import (
+	"image"
+	"image/color"
+	"sync"
+)
+
+// maxCachedColors bounds the memory used by a RecoloredImage cache (about 1M distinct colors)
+const maxCachedColors = 1 << 20
+
+// RecoloredImage is an image.Image view that recolors its source on demand
+// Each call to At maps one source pixel, so callers that read only part of the
+// image, such as thumbnailers and tile servers, never pay for a full-frame pass.
+// It is safe for concurrent use.
+type RecoloredImage struct {
+	src    image.Image
+	mapper *Mapper
+	cache  *colorCache // nil when caching is disabled
+}
+
+// NewRecoloredImage returns a view of src recolored by m
+// If cache is true, mapped colors are remembered by source color, which speeds
+// up repeated reads and images with few distinct colors
+func NewRecoloredImage(src image.Image, m *Mapper, cache bool) *RecoloredImage {
+	img := &RecoloredImage{src: src, mapper: m}
+	if cache {
+		img.cache = &colorCache{colors: make(map[color.RGBA]color.RGBA)}
+	}
+	return img
+}
+
+// ColorModel returns color.RGBAModel, the model of the recolored pixels
+func (r *RecoloredImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the bounds of the source image
+func (r *RecoloredImage) Bounds() image.Rectangle {
+	return r.src.Bounds()
+}
+
+// At returns the recolored pixel at (x, y)
+func (r *RecoloredImage) At(x, y int) color.Color {
+	return r.RGBAAt(x, y)
+}
+
+// RGBAAt returns the recolored pixel at (x, y) as color.RGBA
+func (r *RecoloredImage) RGBAAt(x, y int) color.RGBA {
+	if !(image.Point{X: x, Y: y}.In(r.src.Bounds())) {
+		return color.RGBA{}
+	}
+
+	original := rgbaAt(r.src, x, y)
+	if r.cache == nil {
+		return r.mapper.mapRGBA(original)
+	}
+	if c, ok := r.cache.get(original); ok {
+		return c
+	}
+	c := r.mapper.mapRGBA(original)
+	r.cache.put(original, c)
+	return c
+}
+
+// SubImage returns a view of the part of the image visible through rect
+// The returned image shares the source pixels and the color cache
+func (r *RecoloredImage) SubImage(rect image.Rectangle) image.Image {
+	var src image.Image
+	if s, ok := r.src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		src = s.SubImage(rect)
+	} else {
+		src = &boundedImage{Image: r.src, bounds: rect.Intersect(r.src.Bounds())}
+	}
+	return &RecoloredImage{src: src, mapper: r.mapper, cache: r.cache}
+}
+
+// boundedImage restricts the bounds of an image that has no SubImage method
+type boundedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (b *boundedImage) Bounds() image.Rectangle {
+	return b.bounds
+}
+
+func (b *boundedImage) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(b.bounds)) {
+		return color.RGBA{}
+	}
+	return b.Image.At(x, y)
+}
+
+// colorCache maps source colors to recolored colors
+type colorCache struct {
+	mu     sync.RWMutex
+	colors map[color.RGBA]color.RGBA
+}
+
+func (c *colorCache) get(original color.RGBA) (color.RGBA, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	mapped, ok := c.colors[original]
+	return mapped, ok
+}
+
+func (c *colorCache) put(original, mapped color.RGBA) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.colors) < maxCachedColors {
+		c.colors[original] = mapped
+	}
+}
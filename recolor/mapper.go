@@ -0,0 +1,97 @@
+package recolor
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Mapper maps individual colors onto a palette with the settings from Options
+// It implements color.Model, so Convert recolors a single color, and draw.Drawer,
+// so it can recolor a rectangle from one image into another like draw.FloydSteinberg.
+// A Mapper is safe for concurrent use.
+type Mapper struct {
+	opts         Options
+	paletteRGBAs []color.RGBA
+}
+
+var (
+	_ color.Model = (*Mapper)(nil)
+	_ draw.Drawer = (*Mapper)(nil)
+)
+
+// NewMapper validates opts and returns a Mapper for them
+// OnProgress and Quality are ignored, as a Mapper works on individual colors
+func NewMapper(opts Options) (*Mapper, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pre-convert palette colors to RGBA once
+	paletteRGBAs := make([]color.RGBA, len(opts.Palette))
+	for i, c := range opts.Palette {
+		paletteRGBAs[i] = toRGBA(c)
+	}
+
+	return &Mapper{opts: opts, paletteRGBAs: paletteRGBAs}, nil
+}
+
+// Palette returns the target palette as a color.Palette
+func (m *Mapper) Palette() color.Palette {
+	palette := make(color.Palette, len(m.paletteRGBAs))
+	for i, c := range m.paletteRGBAs {
+		palette[i] = c
+	}
+	return palette
+}
+
+// Convert maps c onto the palette, implementing color.Model
+// Fully transparent colors stay transparent
+func (m *Mapper) Convert(c color.Color) color.Color {
+	return m.mapRGBA(toRGBA(c))
+}
+
+// mapRGBA adjusts the luminosity of c and applies Shepard's Method
+func (m *Mapper) mapRGBA(c color.RGBA) color.RGBA {
+	if c.A == 0 {
+		return color.RGBA{}
+	}
+	adjusted := applyLuminosity(c, m.opts.Luminosity)
+	return toRGBA(shepardsMethodColor(adjusted, m.paletteRGBAs, m.opts.Nearest, m.opts.Power))
+}
+
+// Draw recolors the part of src aligned with r.Min at sp and writes it to r in dst, implementing draw.Drawer
+// Rows are processed concurrently when dst is one of the standard RGBA image types
+func (m *Mapper) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	// Clip r to both images, as draw.Draw does
+	orig := r.Min
+	r = r.Intersect(dst.Bounds())
+	r = r.Intersect(src.Bounds().Add(orig.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	// Offset from a destination point to the matching source point
+	offset := sp.Add(r.Min.Sub(orig)).Sub(r.Min)
+
+	drawRows := func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := m.mapRGBA(rgbaAt(src, x+offset.X, y+offset.Y))
+				if d, ok := dst.(*image.RGBA); ok {
+					d.SetRGBA(x, y, c)
+				} else {
+					dst.Set(x, y, c)
+				}
+			}
+		}
+	}
+
+	switch dst.(type) {
+	case *image.RGBA, *image.NRGBA:
+		forEachRowBand(context.Background(), r.Min.Y, r.Max.Y, m.opts.Workers, drawRows)
+	default:
+		drawRows(r.Min.Y, r.Max.Y)
+	}
+}
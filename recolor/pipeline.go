@@ -0,0 +1,98 @@
+package recolor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// Stage is one step of a Pipeline, such as a resize, a tone adjustment or a recolor
+type Stage interface {
+	// Name identifies the stage in errors and recipes, e.g. "resize"
+	Name() string
+	// Apply transforms img and returns the result, which may be img itself
+	Apply(ctx context.Context, img image.Image) (image.Image, error)
+}
+
+// Pipeline runs a sequence of stages between decoding and encoding an image
+// The zero value is an empty pipeline that passes images through unchanged
+type Pipeline struct {
+	stages []Stage
+
+	// OnStageStart and OnStageEnd, if set, are called around each stage with its Name
+	// OnStageEnd is called whether or not the stage succeeded
+	OnStageStart func(name string)
+	OnStageEnd   func(name string, elapsed time.Duration)
+}
+
+// NewPipeline creates a pipeline that runs the given stages in order
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: append([]Stage(nil), stages...)}
+}
+
+// Then appends a stage to the pipeline and returns the pipeline, for chaining
+func (p *Pipeline) Then(stage Stage) *Pipeline {
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// Stages returns the stages of the pipeline in order
+func (p *Pipeline) Stages() []Stage {
+	return append([]Stage(nil), p.stages...)
+}
+
+// Run applies every stage to img in order
+// It stops at the first failing stage, or when ctx is cancelled between stages
+func (p *Pipeline) Run(ctx context.Context, img image.Image) (image.Image, error) {
+	for i, stage := range p.stages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if p.OnStageStart != nil {
+			p.OnStageStart(stage.Name())
+		}
+		start := time.Now()
+		out, err := stage.Apply(ctx, img)
+		if p.OnStageEnd != nil {
+			p.OnStageEnd(stage.Name(), time.Since(start))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i+1, stage.Name(), err)
+		}
+		img = out
+	}
+	return img, nil
+}
+
+// Process decodes an image from r, runs the pipeline and encodes the result to w
+// If format is empty the input format is kept. It returns the format that was written.
+// Decoding and encoding are not stages, as stages map images to images; they always
+// run first and last.
+func (p *Pipeline) Process(ctx context.Context, r io.Reader, w io.Writer, format Format) (Format, error) {
+	if format != "" {
+		if _, ok := formats[format]; !ok {
+			return "", fmt.Errorf("%w '%s'", ErrUnsupportedFormat, format)
+		}
+	}
+
+	img, inputFormat, err := Decode(r)
+	if err != nil {
+		return "", err
+	}
+	if format == "" {
+		format = inputFormat
+	}
+
+	out, err := p.Run(ctx, img)
+	if err != nil {
+		return "", err
+	}
+
+	if err := Encode(w, out, format); err != nil {
+		return "", err
+	}
+	return format, nil
+}
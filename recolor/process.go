@@ -0,0 +1,83 @@
+package recolor
+
+import (
+	"context"
+	"image"
+	"sync"
+	"sync/atomic"
+)
+
+// progressTracker counts processed pixels and forwards them to Options.OnProgress
+type progressTracker struct {
+	total       int64
+	processed   int64
+	updateMutex sync.Mutex
+	onProgress  func(done, total int64)
+}
+
+// newProgressTracker creates a new progress tracker, onProgress may be nil
+func newProgressTracker(total int64, onProgress func(done, total int64)) *progressTracker {
+	return &progressTracker{
+		total:      total,
+		onProgress: onProgress,
+	}
+}
+
+// updateProgress increments the processed count and reports it
+func (pt *progressTracker) updateProgress(increment int64) {
+	processed := atomic.AddInt64(&pt.processed, increment)
+	if pt.onProgress == nil {
+		return
+	}
+
+	pt.updateMutex.Lock()
+	defer pt.updateMutex.Unlock()
+
+	// A later count may already have been reported by another worker
+	if processed < atomic.LoadInt64(&pt.processed) {
+		return
+	}
+	pt.onProgress(processed, pt.total)
+}
+
+// processImageWithShepardsMethod applies Shepard's Method to each pixel of the image concurrently
+// It uses up to m.opts.Workers goroutines (all CPU cores if workers <= 0) and stops early when ctx is done
+func processImageWithShepardsMethod(
+	ctx context.Context,
+	img image.Image,
+	m *Mapper,
+) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	progress := newProgressTracker(int64(bounds.Dx()*bounds.Dy()), m.opts.OnProgress)
+
+	// Bands cover disjoint rows, so workers write to the output image directly
+	newImg := image.NewRGBA(bounds)
+	err := forEachRowBand(ctx, bounds.Min.Y, bounds.Max.Y, m.opts.Workers, func(startY, endY int) {
+		pixelsProcessed := int64(0)
+		for y := startY; y < endY; y++ {
+			// Stop between rows once the run has been cancelled
+			if ctx.Err() != nil {
+				return
+			}
+
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				// Adjust luminosity and apply Shepard's method
+				newImg.SetRGBA(x, y, m.mapRGBA(rgbaAt(img, x, y)))
+				pixelsProcessed++
+			}
+
+			// Update progress every 10 rows or at last row
+			if (y-startY)%10 == 0 || y == endY-1 {
+				progress.updateProgress(pixelsProcessed)
+				pixelsProcessed = 0
+			}
+		}
+		if pixelsProcessed > 0 {
+			progress.updateProgress(pixelsProcessed)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newImg, nil
+}
@@ -0,0 +1,282 @@
+package recolor
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"runtime"
+	"sync"
+)
+
+const (
+	fastProxyDimension     = 512  // Longest side of the proxy image in fast mode
+	balancedProxyDimension = 1536 // Longest side of the proxy image in balanced mode
+
+	// Joint bilateral upsampling parameters
+	upsampleRadius = 2    // Neighbourhood radius in proxy pixels
+	upsampleSigmaS = 1.0  // Spatial standard deviation in proxy pixels
+	upsampleSigmaR = 24.0 // Range (color) standard deviation in 8-bit RGB units
+)
+
+// proxyDimensionForQuality returns the longest proxy side for a quality level, or 0 for exact processing
+func proxyDimensionForQuality(quality Quality) int {
+	switch quality {
+	case QualityFast:
+		return fastProxyDimension
+	case QualityBalanced:
+		return balancedProxyDimension
+	default:
+		return 0
+	}
+}
+
+// processImageWithQuality recolors img at the given quality level
+// Exact quality maps every pixel; fast and balanced map a downscaled proxy and
+// carry the result back to full resolution with a joint bilateral upsample
+func processImageWithQuality(
+	ctx context.Context,
+	img image.Image,
+	m *Mapper,
+) (*image.RGBA, error) {
+	opts := m.opts
+	maxDim := proxyDimensionForQuality(opts.Quality)
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDim == 0 || (width <= maxDim && height <= maxDim) {
+		return runStage(opts, "map", func() (*image.RGBA, error) {
+			return processImageWithShepardsMethod(ctx, img, m)
+		})
+	}
+
+	// Scale the proxy so that its longest side is maxDim
+	scale := float64(maxDim) / float64(max(width, height))
+	proxyWidth := max(1, int(math.Round(float64(width)*scale)))
+	proxyHeight := max(1, int(math.Round(float64(height)*scale)))
+
+	proxy, err := runStage(opts, "downscale", func() (*image.RGBA, error) {
+		return downscaleImage(ctx, img, proxyWidth, proxyHeight, opts.Workers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, err := runStage(opts, "map", func() (*image.RGBA, error) {
+		return processImageWithShepardsMethod(ctx, proxy, m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return runStage(opts, "upsample", func() (*image.RGBA, error) {
+		return upsampleJointBilateral(ctx, img, proxy, mapped, m)
+	})
+}
+
+// forEachRowBand splits the rows [minY, maxY) into bands and runs fn on each band concurrently
+// It returns ctx.Err() if the context was cancelled before all bands completed
+func forEachRowBand(ctx context.Context, minY, maxY, workers int, fn func(startY, endY int)) error {
+	height := maxY - minY
+	numWorkers := workers
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	if numWorkers > height {
+		numWorkers = height
+	}
+	if numWorkers < 1 {
+		return ctx.Err()
+	}
+	rowsPerWorker := (height + numWorkers - 1) / numWorkers
+
+	var wg sync.WaitGroup
+	for id := 0; id < numWorkers; id++ {
+		startY := minY + id*rowsPerWorker
+		endY := min(startY+rowsPerWorker, maxY)
+		if startY >= endY {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(startY, endY)
+		}()
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// rgbaAt returns the pixel at (x, y) as color.RGBA
+// Common image types are read directly to avoid boxing every pixel in a color.Color
+func rgbaAt(img image.Image, x, y int) color.RGBA {
+	var r, g, b, a uint32
+	switch m := img.(type) {
+	case *image.RGBA:
+		return m.RGBAAt(x, y)
+	case *image.NRGBA:
+		r, g, b, a = m.NRGBAAt(x, y).RGBA()
+	case *image.YCbCr:
+		r, g, b, a = m.YCbCrAt(x, y).RGBA()
+	case *image.Gray:
+		r, g, b, a = m.GrayAt(x, y).RGBA()
+	default:
+		return toRGBA(img.At(x, y))
+	}
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+// downscaleImage shrinks img to width x height by averaging the source pixels under each target pixel
+func downscaleImage(ctx context.Context, img image.Image, width, height int, workers int) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	err := forEachRowBand(ctx, 0, height, workers, func(startY, endY int) {
+		for py := startY; py < endY; py++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			y0 := bounds.Min.Y + py*srcHeight/height
+			y1 := max(bounds.Min.Y+(py+1)*srcHeight/height, y0+1)
+
+			for px := 0; px < width; px++ {
+				x0 := bounds.Min.X + px*srcWidth/width
+				x1 := max(bounds.Min.X+(px+1)*srcWidth/width, x0+1)
+
+				var sumR, sumG, sumB, sumA, count uint64
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						c := rgbaAt(img, x, y)
+						sumR += uint64(c.R)
+						sumG += uint64(c.G)
+						sumB += uint64(c.B)
+						sumA += uint64(c.A)
+						count++
+					}
+				}
+
+				dst.SetRGBA(px, py, color.RGBA{
+					R: uint8((sumR + count/2) / count),
+					G: uint8((sumG + count/2) / count),
+					B: uint8((sumB + count/2) / count),
+					A: uint8((sumA + count/2) / count),
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+// upsampleJointBilateral carries the proxy mapping back to the full-resolution image
+// Each output pixel blends the mapped colors of nearby proxy pixels, weighted by spatial
+// distance and by how similar the proxy's source color is to the full-resolution pixel,
+// so that edges in the original image stay sharp
+func upsampleJointBilateral(
+	ctx context.Context,
+	img image.Image,
+	proxy *image.RGBA,
+	mapped *image.RGBA,
+	m *Mapper,
+) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	proxyBounds := proxy.Bounds()
+	proxyWidth, proxyHeight := proxyBounds.Dx(), proxyBounds.Dy()
+
+	scaleX := float64(proxyWidth) / float64(width)
+	scaleY := float64(proxyHeight) / float64(height)
+
+	spatialDenom := 2 * upsampleSigmaS * upsampleSigmaS
+	rangeDenom := 2 * upsampleSigmaR * upsampleSigmaR
+
+	// Precompute range weights for every possible squared RGB distance
+	rangeWeights := make([]float64, 3*255*255+1)
+	for d := range rangeWeights {
+		rangeWeights[d] = math.Exp(-float64(d) / rangeDenom)
+	}
+
+	dst := image.NewRGBA(bounds)
+
+	err := forEachRowBand(ctx, bounds.Min.Y, bounds.Max.Y, m.opts.Workers, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Position of this row in proxy coordinates
+			fy := (float64(y-bounds.Min.Y)+0.5)*scaleY - 0.5
+			cy := int(math.Floor(fy))
+
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				original := rgbaAt(img, x, y)
+				if original.A == 0 {
+					dst.Set(x, y, color.Transparent)
+					continue
+				}
+
+				fx := (float64(x-bounds.Min.X)+0.5)*scaleX - 0.5
+				cx := int(math.Floor(fx))
+
+				// The spatial kernel is separable, so compute the horizontal and vertical factors once
+				var weightsX, weightsY [2 * upsampleRadius]float64
+				for i := range weightsX {
+					dx := float64(cx-upsampleRadius+1+i) - fx
+					dy := float64(cy-upsampleRadius+1+i) - fy
+					weightsX[i] = math.Exp(-(dx * dx) / spatialDenom)
+					weightsY[i] = math.Exp(-(dy * dy) / spatialDenom)
+				}
+
+				var sumR, sumG, sumB, totalWeight float64
+				for j, qy := 0, cy-upsampleRadius+1; qy <= cy+upsampleRadius; j, qy = j+1, qy+1 {
+					if qy < 0 || qy >= proxyHeight {
+						continue
+					}
+					for i, qx := 0, cx-upsampleRadius+1; qx <= cx+upsampleRadius; i, qx = i+1, qx+1 {
+						if qx < 0 || qx >= proxyWidth {
+							continue
+						}
+
+						mc := mapped.RGBAAt(qx, qy)
+						if mc.A == 0 {
+							continue
+						}
+						s := proxy.RGBAAt(qx, qy)
+
+						weight := weightsX[i] * weightsY[j] * rangeWeights[int(colorDistanceSquared(original, s))]
+
+						sumR += float64(mc.R) * weight
+						sumG += float64(mc.G) * weight
+						sumB += float64(mc.B) * weight
+						totalWeight += weight
+					}
+				}
+
+				// No usable neighbours (e.g. at the edge of a transparent region), map this pixel directly
+				if totalWeight < 1e-12 {
+					dst.SetRGBA(x, y, m.mapRGBA(original))
+					continue
+				}
+
+				dst.SetRGBA(x, y, color.RGBA{
+					R: uint8(math.Round(sumR / totalWeight)),
+					G: uint8(math.Round(sumG / totalWeight)),
+					B: uint8(math.Round(sumB / totalWeight)),
+					A: 255,
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,200 @@
+package recolor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+
+	"github.com/ashish0kumar/tint/themes"
+)
+
+// Recipe is a declarative description of a Pipeline, usually read from a JSON file
+//
+//	{
+//	  "theme": "catppuccin-mocha",
+//	  "stages": [
+//	    {"type": "resize", "width": 1920},
+//	    {"type": "tone", "contrast": 1.1},
+//	    {"type": "recolor", "quality": "balanced"},
+//	    {"type": "dither"}
+//	  ]
+//	}
+type Recipe struct {
+	Theme  string        `json:"theme,omitempty"`  // Theme used by stages that do not name one
+	Format Format        `json:"format,omitempty"` // Output format, empty keeps the input format
+	Stages []RecipeStage `json:"stages"`
+}
+
+// RecipeStage describes one stage of a Recipe
+// Type selects the stage and only the fields that apply to it may be set
+type RecipeStage struct {
+	Type string `json:"type"` // orient, resize, crop, tone, recolor or dither
+
+	// orient
+	Rotate int  `json:"rotate,omitempty"`
+	FlipH  bool `json:"flip_h,omitempty"`
+	FlipV  bool `json:"flip_v,omitempty"`
+
+	// resize and crop
+	X      int `json:"x,omitempty"`
+	Y      int `json:"y,omitempty"`
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// tone
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+	Saturation float64 `json:"saturation,omitempty"`
+	Gamma      float64 `json:"gamma,omitempty"`
+
+	// recolor and dither
+	Theme      string  `json:"theme,omitempty"`
+	Luminosity float64 `json:"luminosity,omitempty"`
+	Nearest    int     `json:"nearest,omitempty"`
+	Power      float64 `json:"power,omitempty"`
+	Quality    Quality `json:"quality,omitempty"`
+}
+
+// ParseRecipe reads a JSON recipe, rejecting unknown fields
+func ParseRecipe(r io.Reader) (*Recipe, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var recipe Recipe
+	if err := dec.Decode(&recipe); err != nil {
+		return nil, fmt.Errorf("invalid recipe: %v", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid recipe: unexpected data after the recipe object")
+	}
+	if recipe.Format != "" {
+		if _, ok := formats[recipe.Format]; !ok {
+			return nil, fmt.Errorf("invalid recipe: %w '%s'", ErrUnsupportedFormat, recipe.Format)
+		}
+	}
+	if len(recipe.Stages) == 0 {
+		return nil, fmt.Errorf("invalid recipe: no stages")
+	}
+
+	// Recipes are data, so they must not name a program to run
+	if themes.IsProvider(recipe.Theme) {
+		return nil, fmt.Errorf("invalid recipe: theme '%s' runs a program, external providers are only accepted from --theme", recipe.Theme)
+	}
+	for i, s := range recipe.Stages {
+		if themes.IsProvider(s.Theme) {
+			return nil, fmt.Errorf("invalid recipe: stage %d theme '%s' runs a program, external providers are only accepted from --theme", i+1, s.Theme)
+		}
+		if s.Type == "orient" {
+			if err := (OrientStage{Rotate: s.Rotate}).validate(); err != nil {
+				return nil, fmt.Errorf("invalid recipe: stage %d (orient): %w", i+1, err)
+			}
+		}
+	}
+	return &recipe, nil
+}
+
+// LoadRecipe reads a JSON recipe from a file
+func LoadRecipe(path string) (*Recipe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read recipe '%s': %v", path, err)
+	}
+	recipe, err := ParseRecipe(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return recipe, nil
+}
+
+// Pipeline builds the stages of the recipe
+// base provides the options of recolor stages; values set on a stage override it.
+// base.Workers also limits the goroutines of the resize and tone stages.
+// A stage theme takes precedence over base.Palette and base.Theme, which in turn
+// take precedence over the recipe theme.
+func (rc *Recipe) Pipeline(base Options) (*Pipeline, error) {
+	if len(base.Palette) == 0 && base.Theme == "" {
+		base.Theme = rc.Theme
+	}
+
+	p := NewPipeline()
+	for i, s := range rc.Stages {
+		stage, err := s.stage(base)
+		if err != nil {
+			return nil, fmt.Errorf("recipe stage %d (%s): %w", i+1, s.Type, err)
+		}
+		p.Then(stage)
+	}
+	return p, nil
+}
+
+// stage converts the description into a Stage
+func (s RecipeStage) stage(base Options) (Stage, error) {
+	switch s.Type {
+	case "orient":
+		stage := OrientStage{Rotate: s.Rotate, FlipH: s.FlipH, FlipV: s.FlipV}
+		if err := stage.validate(); err != nil {
+			return nil, err
+		}
+		return stage, nil
+	case "resize":
+		return ResizeStage{Width: s.Width, Height: s.Height, Workers: base.Workers}, nil
+	case "crop":
+		return CropStage{X: s.X, Y: s.Y, Width: s.Width, Height: s.Height}, nil
+	case "tone":
+		return ToneStage{Brightness: s.Brightness, Contrast: s.Contrast, Saturation: s.Saturation, Gamma: s.Gamma, Workers: base.Workers}, nil
+	case "recolor":
+		opts := base
+		if s.Theme != "" {
+			opts.Palette = nil
+			opts.Theme = s.Theme
+		}
+		if s.Luminosity != 0 {
+			opts.Luminosity = s.Luminosity
+		}
+		if s.Nearest != 0 {
+			opts.Nearest = s.Nearest
+		}
+		if s.Power != 0 {
+			opts.Power = s.Power
+		}
+		if s.Quality != "" {
+			opts.Quality = s.Quality
+		}
+		if _, err := opts.withDefaults(); err != nil {
+			return nil, err
+		}
+		return RecolorStage{Options: opts}, nil
+	case "dither":
+		palette, err := stagePalette(s.Theme, base)
+		if err != nil {
+			return nil, err
+		}
+		return DitherStage{Palette: palette}, nil
+	case "":
+		return nil, fmt.Errorf("missing stage type")
+	default:
+		return nil, fmt.Errorf("unknown stage type '%s'", s.Type)
+	}
+}
+
+// stagePalette resolves the palette of a stage from its own theme or the base options
+func stagePalette(theme string, base Options) (color.Palette, error) {
+	if theme == "" && len(base.Palette) > 0 {
+		return color.Palette(base.Palette), nil
+	}
+	if theme == "" {
+		theme = base.Theme
+	}
+	if theme == "" {
+		return nil, fmt.Errorf("no theme set for the stage or the recipe")
+	}
+
+	palette, err := themes.GetPalette(theme)
+	if err != nil {
+		return nil, err
+	}
+	return palette.ColorPalette(), nil
+}
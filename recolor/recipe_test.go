@@ -0,0 +1,67 @@
+package recolor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecipeWorkers(t *testing.T) {
+	recipe, err := ParseRecipe(strings.NewReader(`{
+		"theme": "nord",
+		"stages": [
+			{"type": "resize", "width": 64},
+			{"type": "tone", "contrast": 1.2},
+			{"type": "recolor"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := recipe.Pipeline(Options{Workers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every stage that runs concurrently must respect the worker limit
+	for _, stage := range p.Stages() {
+		var workers int
+		switch s := stage.(type) {
+		case ResizeStage:
+			workers = s.Workers
+		case ToneStage:
+			workers = s.Workers
+		case RecolorStage:
+			workers = s.Options.Workers
+		default:
+			t.Fatalf("unexpected stage %T", stage)
+		}
+		if workers != 3 {
+			t.Errorf("%s stage has %d workers, want 3", stage.Name(), workers)
+		}
+	}
+}
+
+func TestRecipeRejectsProviders(t *testing.T) {
+	for _, recipe := range []string{
+		`{"theme": "exec:./palette.sh", "stages": [{"type": "recolor"}]}`,
+		`{"theme": "nord", "stages": [{"type": "recolor", "theme": " exec:/usr/local/bin/palette"}]}`,
+		`{"stages": [{"type": "dither", "theme": "exec:./palette.sh nord"}]}`,
+	} {
+		if _, err := ParseRecipe(strings.NewReader(recipe)); err == nil || !strings.Contains(err.Error(), "--theme") {
+			t.Errorf("ParseRecipe(%s) error = %v, want a rejected provider", recipe, err)
+		}
+	}
+}
+
+func TestRecipeInvalidRotation(t *testing.T) {
+	_, err := ParseRecipe(strings.NewReader(`{"theme": "nord", "stages": [{"type": "orient", "rotate": 45}]}`))
+	if err == nil || !strings.Contains(err.Error(), "multiple of 90") {
+		t.Errorf("ParseRecipe error = %v, want a rejected rotation", err)
+	}
+
+	// Recipes built in code are checked when the pipeline is built
+	recipe := &Recipe{Theme: "nord", Stages: []RecipeStage{{Type: "orient", Rotate: 100}}}
+	if _, err := recipe.Pipeline(Options{}); err == nil {
+		t.Error("Pipeline accepted a rotation of 100 degrees")
+	}
+}
@@ -0,0 +1,165 @@
+// Package recolor recolors images with theme palettes using Shepard's Method
+//
+// It is the engine behind the tint command-line tool, and can be imported by
+// other programs that need to recolor images without shelling out to tint
+package recolor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/ashish0kumar/tint/themes"
+)
+
+// Default parameters for Shepard's Method
+const (
+	DefaultLuminosity = 1.0
+	DefaultNearest    = 30
+	DefaultPower      = 4.0
+)
+
+// Method selects the color mapping algorithm
+type Method string
+
+const (
+	// MethodShepard blends the nearest palette colors using inverse distance weighting
+	MethodShepard Method = "shepard"
+)
+
+// Quality trades accuracy for speed on large images
+type Quality string
+
+const (
+	// QualityExact maps every pixel of the full image
+	QualityExact Quality = "exact"
+	// QualityBalanced maps a proxy with a longest side of 1536 pixels and upsamples the result
+	QualityBalanced Quality = "balanced"
+	// QualityFast maps a proxy with a longest side of 512 pixels and upsamples the result
+	QualityFast Quality = "fast"
+)
+
+// ParseQuality converts a quality name such as "fast" into a Quality
+func ParseQuality(s string) (Quality, error) {
+	switch q := Quality(strings.ToLower(strings.TrimSpace(s))); q {
+	case QualityExact, QualityBalanced, QualityFast:
+		return q, nil
+	case "":
+		return QualityExact, nil
+	default:
+		return "", fmt.Errorf("invalid quality '%s'. Use fast, balanced or exact", s)
+	}
+}
+
+// Options configures a call to Recolor
+// Zero values select the defaults, so only Palette or Theme has to be set
+type Options struct {
+	// Palette is the set of target colors, e.g. from themes.GetPalette
+	Palette []color.Color
+	// Theme is a theme name such as "catppuccin-mocha", used when Palette is empty
+	Theme string
+
+	// Luminosity scales the brightness of each pixel before mapping (default 1.0)
+	Luminosity float64
+	// Nearest is the number of nearest palette colors blended per pixel (default 30)
+	Nearest int
+	// Power controls how quickly the blending weights fall off with distance (default 4.0)
+	Power float64
+
+	// Workers is the number of goroutines used for processing (default: number of CPU cores)
+	Workers int
+	// Method is the mapping algorithm (default MethodShepard)
+	Method Method
+	// Quality selects exact or proxy-based processing (default QualityExact)
+	Quality Quality
+
+	// OnProgress, if set, is called as pixels are mapped, with the number of pixels
+	// done so far and the total. Calls are serialized, and the last call has done == total.
+	// With fast or balanced quality the total is the size of the downscaled proxy.
+	OnProgress func(done, total int64)
+
+	// OnStageStart and OnStageEnd, if set, are called around each internal step of
+	// Recolor: "downscale", "map" and "upsample" with fast or balanced quality, and
+	// only "map" otherwise. OnStageEnd is called whether or not the step succeeded.
+	OnStageStart func(name string)
+	OnStageEnd   func(name string, elapsed time.Duration)
+}
+
+// runStage runs fn as the named step, reporting it to OnStageStart and OnStageEnd
+func runStage[T any](o Options, name string, fn func() (T, error)) (T, error) {
+	if o.OnStageStart != nil {
+		o.OnStageStart(name)
+	}
+	start := time.Now()
+	out, err := fn()
+	if o.OnStageEnd != nil {
+		o.OnStageEnd(name, time.Since(start))
+	}
+	return out, err
+}
+
+// withDefaults validates the options and fills in defaults for zero values
+func (o Options) withDefaults() (Options, error) {
+	if len(o.Palette) == 0 && o.Theme != "" {
+		palette, err := themes.GetPalette(o.Theme)
+		if err != nil {
+			return o, err
+		}
+		o.Palette = palette.Colors()
+	}
+	if len(o.Palette) == 0 {
+		return o, errors.New("palette must contain at least one color")
+	}
+
+	if o.Luminosity == 0 {
+		o.Luminosity = DefaultLuminosity
+	}
+	if o.Nearest == 0 {
+		o.Nearest = DefaultNearest
+	}
+	if o.Power == 0 {
+		o.Power = DefaultPower
+	}
+	if o.Method == "" {
+		o.Method = MethodShepard
+	}
+	if o.Quality == "" {
+		o.Quality = QualityExact
+	}
+
+	if o.Luminosity < 0 {
+		return o, fmt.Errorf("luminosity must be positive, got %.2f", o.Luminosity)
+	}
+	if o.Nearest < 1 {
+		return o, fmt.Errorf("nearest colors count must be at least 1, got %d", o.Nearest)
+	}
+	if o.Power < 0 {
+		return o, fmt.Errorf("power must be positive, got %.2f", o.Power)
+	}
+	if o.Workers < 0 {
+		return o, fmt.Errorf("workers must not be negative, got %d", o.Workers)
+	}
+	if o.Method != MethodShepard {
+		return o, fmt.Errorf("unsupported method '%s'", o.Method)
+	}
+	if _, err := ParseQuality(string(o.Quality)); err != nil {
+		return o, err
+	}
+
+	return o, nil
+}
+
+// Recolor maps every pixel of img onto the palette in opts and returns the new image
+// It stops early and returns ctx.Err() when ctx is cancelled
+func Recolor(ctx context.Context, img image.Image, opts Options) (image.Image, error) {
+	m, err := NewMapper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return processImageWithQuality(ctx, img, m)
+}
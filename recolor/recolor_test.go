@@ -0,0 +1,21 @@
+package recolor
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestRecolorEmptyImage(t *testing.T) {
+	for _, quality := range []Quality{QualityExact, QualityBalanced, QualityFast} {
+		for _, rect := range []image.Rectangle{image.Rect(0, 0, 5, 0), image.Rect(0, 0, 0, 5), image.Rect(3, 3, 3, 3)} {
+			out, err := Recolor(context.Background(), image.NewRGBA(rect), Options{Theme: "nord", Quality: quality})
+			if err != nil {
+				t.Fatalf("Recolor(%v, %s): %v", rect, quality, err)
+			}
+			if !out.Bounds().Empty() {
+				t.Errorf("Recolor(%v, %s) returned bounds %v, want empty", rect, quality, out.Bounds())
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package recolor
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// toRGBA converts any color.Color to color.RGBA
+func toRGBA(c color.Color) color.RGBA {
+	r, g, b, a := c.RGBA()
+	return color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+}
+
+// colorDistanceSquared calculates the squared euclidean distance between two colors in RGB space
+func colorDistanceSquared(c1, c2 color.RGBA) float64 {
+	dr := float64(c1.R) - float64(c2.R)
+	dg := float64(c1.G) - float64(c2.G)
+	db := float64(c1.B) - float64(c2.B)
+
+	return dr*dr + dg*dg + db*db
+}
+
+// findNClosestColors finds the N closest colors in the given palette to the original color
+// It returns a slice of structs containing the distance and the color, sorted by distance
+// Colors at equal distance keep their palette order, so the result is deterministic
+func findNClosestColors(originalRGBA color.RGBA, paletteRGBAs []color.RGBA, n int) []struct {
+	dist  float64
+	color color.Color
+} {
+	if len(paletteRGBAs) == 0 {
+		return nil
+	}
+
+	distances := make([]struct {
+		dist  float64
+		color color.Color
+	}, 0, len(paletteRGBAs))
+
+	for _, pRGBA := range paletteRGBAs {
+		distances = append(distances, struct {
+			dist  float64
+			color color.Color
+		}{dist: colorDistanceSquared(originalRGBA, pRGBA), color: pRGBA})
+	}
+
+	sort.SliceStable(distances, func(i, j int) bool {
+		return distances[i].dist < distances[j].dist
+	})
+
+	if n > len(distances) {
+		n = len(distances)
+	}
+	return distances[:n]
+}
+
+// blendColors takes a slice of colors and their corresponding weights and returns a single blended color
+func blendColors(colors []color.Color, weights []float64) color.RGBA {
+	if len(colors) == 0 || len(colors) != len(weights) {
+		return color.RGBA{}
+	}
+
+	var sumR, sumG, sumB float64
+	var totalWeight float64
+
+	for i := range colors {
+		rgba := toRGBA(colors[i])
+		sumR += float64(rgba.R) * weights[i]
+		sumG += float64(rgba.G) * weights[i]
+		sumB += float64(rgba.B) * weights[i]
+		totalWeight += weights[i]
+	}
+
+	if totalWeight == 0 {
+		return toRGBA(colors[0]) // Fallback to the first color if weights are somehow zero
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round(sumR / totalWeight)),
+		G: uint8(math.Round(sumG / totalWeight)),
+		B: uint8(math.Round(sumB / totalWeight)),
+		A: 255,
+	}
+}
+
+// applyLuminosity adjusts a color's brightness by scaling its RGB components
+func applyLuminosity(c color.RGBA, factor float64) color.RGBA {
+	r := uint8(math.Max(0, math.Min(255, float64(c.R)*factor)))
+	g := uint8(math.Max(0, math.Min(255, float64(c.G)*factor)))
+	b := uint8(math.Max(0, math.Min(255, float64(c.B)*factor)))
+	return color.RGBA{R: r, G: g, B: b, A: c.A}
+}
+
+// shepardsMethodColor applies Shepard's Method for color interpolation
+// It finds the 'nearest' palette colors and blends them using inverse distance weighting
+func shepardsMethodColor(originalRGBA color.RGBA, paletteRGBAs []color.RGBA, nearest int, power float64) color.Color {
+	closest := findNClosestColors(originalRGBA, paletteRGBAs, nearest)
+	if len(closest) == 0 {
+		return originalRGBA // No palette colors available, return original color
+	}
+	// If an exact match is found or only one neighbor is requested, just return it
+	if len(closest) == 1 || closest[0].dist == 0 {
+		return closest[0].color
+	}
+
+	weights := make([]float64, len(closest))
+	var totalWeight float64
+	for i, c := range closest {
+		if c.dist == 0 { // Avoid division by zero
+			return c.color
+		}
+		// Inverse distance weighting
+		weight := 1.0 / math.Pow(math.Sqrt(c.dist), power)
+		weights[i] = weight
+		totalWeight += weight
+	}
+
+	if totalWeight == 0 { // Fallback if all weights somehow sum to zero
+		return closest[0].color
+	}
+
+	return blendColors(extractColors(closest), weights)
+}
+
+// extractColors pulls just the color.Color from the sorted slice of (distance, color) tuples
+func extractColors(sortedColors []struct {
+	dist  float64
+	color color.Color
+}) []color.Color {
+	colors := make([]color.Color, len(sortedColors))
+	for i, item := range sortedColors {
+		colors[i] = item.color
+	}
+	return colors
+}
@@ -0,0 +1,326 @@
+package recolor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// OrientStage rotates the image clockwise by a multiple of 90 degrees, then optionally flips it
+type OrientStage struct {
+	Rotate int  // Clockwise rotation in degrees: 0, 90, 180 or 270
+	FlipH  bool // Mirror left to right after rotating
+	FlipV  bool // Mirror top to bottom after rotating
+}
+
+func (s OrientStage) Name() string { return "orient" }
+
+// validate checks the rotation, so recipes can reject it before any image is decoded
+func (s OrientStage) validate() error {
+	if s.Rotate%90 != 0 {
+		return fmt.Errorf("rotation must be a multiple of 90 degrees, got %d", s.Rotate)
+	}
+	return nil
+}
+
+func (s OrientStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	rotate := ((s.Rotate % 360) + 360) % 360
+	if rotate == 0 && !s.FlipH && !s.FlipV {
+		return img, nil
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	outW, outH := w, h
+	if rotate == 90 || rotate == 270 {
+		outW, outH = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Position after rotating clockwise
+			var dx, dy int
+			switch rotate {
+			case 0:
+				dx, dy = x, y
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			if s.FlipH {
+				dx = outW - 1 - dx
+			}
+			if s.FlipV {
+				dy = outH - 1 - dy
+			}
+			dst.SetRGBA(dx, dy, rgbaAt(img, bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst, nil
+}
+
+// EXIFOrientStage returns the stage that turns an image stored with the given
+// EXIF orientation (1 to 8) upright. Other values leave the image unchanged.
+func EXIFOrientStage(orientation int) OrientStage {
+	switch orientation {
+	case 2:
+		return OrientStage{FlipH: true}
+	case 3:
+		return OrientStage{Rotate: 180}
+	case 4:
+		return OrientStage{FlipV: true}
+	case 5: // Transpose
+		return OrientStage{Rotate: 90, FlipH: true}
+	case 6:
+		return OrientStage{Rotate: 90}
+	case 7: // Transverse
+		return OrientStage{Rotate: 270, FlipH: true}
+	case 8:
+		return OrientStage{Rotate: 270}
+	default:
+		return OrientStage{}
+	}
+}
+
+// ResizeStage scales the image to Width x Height
+// If one of them is zero it is derived from the other, keeping the aspect ratio.
+// Downscaling averages source pixels, upscaling interpolates bilinearly.
+type ResizeStage struct {
+	Width  int
+	Height int
+
+	// Workers is the number of goroutines used (default: number of CPU cores)
+	Workers int
+}
+
+func (s ResizeStage) Name() string { return "resize" }
+
+func (s ResizeStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	w, h := s.Width, s.Height
+	switch {
+	case w < 0 || h < 0 || (w == 0 && h == 0):
+		return nil, fmt.Errorf("invalid size %dx%d, set a positive width, height or both", s.Width, s.Height)
+	case w == 0:
+		w = max(1, int(math.Round(float64(srcW)*float64(h)/float64(srcH))))
+	case h == 0:
+		h = max(1, int(math.Round(float64(srcH)*float64(w)/float64(srcW))))
+	}
+	if err := checkDimensions(w, h); err != nil {
+		return nil, err
+	}
+	if w == srcW && h == srcH {
+		return img, nil
+	}
+
+	if w <= srcW && h <= srcH {
+		return downscaleImage(ctx, img, w, h, s.Workers)
+	}
+	return upscaleBilinear(ctx, img, w, h, s.Workers)
+}
+
+// upscaleBilinear scales img to width x height with bilinear interpolation, using up to workers goroutines
+func upscaleBilinear(ctx context.Context, img image.Image, width, height, workers int) (*image.RGBA, error) {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	err := forEachRowBand(ctx, 0, height, workers, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+
+			fy := math.Max(0, (float64(y)+0.5)*float64(srcH)/float64(height)-0.5)
+			y0 := min(int(fy), srcH-1)
+			y1 := min(y0+1, srcH-1)
+			ty := fy - float64(y0)
+
+			for x := 0; x < width; x++ {
+				fx := math.Max(0, (float64(x)+0.5)*float64(srcW)/float64(width)-0.5)
+				x0 := min(int(fx), srcW-1)
+				x1 := min(x0+1, srcW-1)
+				tx := fx - float64(x0)
+
+				c00 := rgbaAt(img, bounds.Min.X+x0, bounds.Min.Y+y0)
+				c10 := rgbaAt(img, bounds.Min.X+x1, bounds.Min.Y+y0)
+				c01 := rgbaAt(img, bounds.Min.X+x0, bounds.Min.Y+y1)
+				c11 := rgbaAt(img, bounds.Min.X+x1, bounds.Min.Y+y1)
+
+				lerp := func(a, b, c, d uint8) uint8 {
+					top := float64(a)*(1-tx) + float64(b)*tx
+					bottom := float64(c)*(1-tx) + float64(d)*tx
+					return uint8(math.Round(top*(1-ty) + bottom*ty))
+				}
+
+				dst.SetRGBA(x, y, color.RGBA{
+					R: lerp(c00.R, c10.R, c01.R, c11.R),
+					G: lerp(c00.G, c10.G, c01.G, c11.G),
+					B: lerp(c00.B, c10.B, c01.B, c11.B),
+					A: lerp(c00.A, c10.A, c01.A, c11.A),
+				})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// CropStage keeps the rectangle at (X, Y) of size Width x Height, relative to the top-left corner
+type CropStage struct {
+	X, Y          int
+	Width, Height int
+}
+
+func (s CropStage) Name() string { return "crop" }
+
+func (s CropStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	rect := image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height).Add(bounds.Min)
+	if s.Width <= 0 || s.Height <= 0 || !rect.In(bounds) {
+		return nil, fmt.Errorf("crop %dx%d at (%d, %d) does not fit in the %dx%d image",
+			s.Width, s.Height, s.X, s.Y, bounds.Dx(), bounds.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst, nil
+}
+
+// ToneStage adjusts brightness, contrast, saturation and gamma
+// Zero values leave the corresponding property unchanged
+type ToneStage struct {
+	Brightness float64 // Added to every channel, from -1 to 1
+	Contrast   float64 // Multiplier around mid-grey, 1 is unchanged
+	Saturation float64 // Multiplier of the distance from grey, 1 is unchanged
+	Gamma      float64 // Gamma correction exponent, 1 is unchanged
+
+	// Workers is the number of goroutines used (default: number of CPU cores)
+	Workers int
+}
+
+func (s ToneStage) Name() string { return "tone" }
+
+func (s ToneStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	contrast, saturation, gamma := s.Contrast, s.Saturation, s.Gamma
+	if contrast == 0 {
+		contrast = 1
+	}
+	if saturation == 0 {
+		saturation = 1
+	}
+	if gamma == 0 {
+		gamma = 1
+	}
+	if contrast < 0 || saturation < 0 || gamma < 0 {
+		return nil, fmt.Errorf("contrast, saturation and gamma must be positive")
+	}
+	if s.Brightness == 0 && contrast == 1 && saturation == 1 && gamma == 1 {
+		return img, nil
+	}
+
+	// Precompute the per-channel curve for brightness, contrast and gamma
+	var curve [256]float64
+	for i := range curve {
+		v := float64(i) / 255
+		v = (v-0.5)*contrast + 0.5 + s.Brightness
+		v = math.Max(0, math.Min(1, v))
+		curve[i] = math.Pow(v, 1/gamma)
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(bounds)
+	err := forEachRowBand(ctx, bounds.Min.Y, bounds.Max.Y, s.Workers, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				r, g, b := curve[c.R], curve[c.G], curve[c.B]
+
+				// Rec. 709 luma as the grey point for saturation
+				luma := 0.2126*r + 0.7152*g + 0.0722*b
+				r = luma + (r-luma)*saturation
+				g = luma + (g-luma)*saturation
+				b = luma + (b-luma)*saturation
+
+				dst.SetNRGBA(x, y, color.NRGBA{R: unitToByte(r), G: unitToByte(g), B: unitToByte(b), A: c.A})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// unitToByte converts a value from 0 to 1 into a clamped 8-bit channel
+func unitToByte(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+}
+
+// RecolorStage maps the image onto a palette with Recolor
+type RecolorStage struct {
+	Options Options
+}
+
+func (s RecolorStage) Name() string { return "recolor" }
+
+func (s RecolorStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	return Recolor(ctx, img, s.Options)
+}
+
+// DitherStage reduces the image to exactly the colors of a palette with Floyd-Steinberg dithering
+// A transparent entry is added when the image has transparent pixels and the palette has room
+type DitherStage struct {
+	Palette color.Palette
+}
+
+func (s DitherStage) Name() string { return "dither" }
+
+func (s DitherStage) Apply(ctx context.Context, img image.Image) (image.Image, error) {
+	if len(s.Palette) == 0 || len(s.Palette) > 256 {
+		return nil, fmt.Errorf("palette must have between 1 and 256 colors, got %d", len(s.Palette))
+	}
+
+	palette := append(color.Palette(nil), s.Palette...)
+	if len(palette) < 256 && hasTransparency(img) {
+		palette = append(palette, color.RGBA{})
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewPaletted(bounds, palette)
+	draw.FloydSteinberg.Draw(dst, bounds, img, bounds.Min)
+	return dst, nil
+}
+
+// hasTransparency reports whether any pixel of img is fully transparent
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return false
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if rgbaAt(img, x, y).A == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
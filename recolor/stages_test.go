@@ -0,0 +1,55 @@
+package recolor
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEXIFOrientStage(t *testing.T) {
+	// Every pixel of the upright 3x2 image has its own color
+	upright := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := 0; i < 6; i++ {
+		upright.SetRGBA(i%3, i/3, color.RGBA{uint8(i), 0, 0, 0xff})
+	}
+
+	// Stored rows, top to bottom, for each orientation from the EXIF specification
+	stored := map[int][][]uint8{
+		1: {{0, 1, 2}, {3, 4, 5}},
+		2: {{2, 1, 0}, {5, 4, 3}},
+		3: {{5, 4, 3}, {2, 1, 0}},
+		4: {{3, 4, 5}, {0, 1, 2}},
+		5: {{0, 3}, {1, 4}, {2, 5}},
+		6: {{2, 5}, {1, 4}, {0, 3}},
+		7: {{5, 2}, {4, 1}, {3, 0}},
+		8: {{3, 0}, {4, 1}, {5, 2}},
+	}
+	for orientation, rows := range stored {
+		img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+		for y, row := range rows {
+			for x, v := range row {
+				img.SetRGBA(x, y, color.RGBA{v, 0, 0, 0xff})
+			}
+		}
+		got, err := EXIFOrientStage(orientation).Apply(context.Background(), img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Bounds() != upright.Bounds() || !equalRGBA(got, upright) {
+			t.Errorf("orientation %d did not turn the image upright", orientation)
+		}
+	}
+}
+
+// equalRGBA reports whether a and b have the same pixels, with b's bounds
+func equalRGBA(a image.Image, b *image.RGBA) bool {
+	for y := b.Rect.Min.Y; y < b.Rect.Max.Y; y++ {
+		for x := b.Rect.Min.X; x < b.Rect.Max.X; x++ {
+			if rgbaAt(a, x, y) != b.RGBAAt(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/ashish0kumar/tint/recolor"
+)
+
+// byteUnits are the suffixes accepted by --max-size, as multiples of 1024
+var byteUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize converts a size such as "500KB" or "1.5M" into bytes
+func parseByteSize(s string) (int64, error) {
+	cleaned := strings.ToUpper(strings.TrimSpace(s))
+	scale := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(cleaned, unit.suffix) {
+			cleaned = strings.TrimSpace(strings.TrimSuffix(cleaned, unit.suffix))
+			scale = unit.scale
+			break
+		}
+	}
+	// Sizes that round down to zero bytes or overflow are invalid too
+	value, err := strconv.ParseFloat(cleaned, 64)
+	n := value * float64(scale)
+	if err != nil || !(n >= 1 && n < math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size '%s'. Use a number of bytes or a size such as 500KB or 2MB", s)
+	}
+	return int64(n), nil
+}
+
+// formatByteSize prints a byte count in the units used by --max-size
+func formatByteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+// fitOutput encodes the output with write and checks that it is at most maxSize bytes
+// For JPEG output the quality is lowered as needed: a binary search finds the highest
+// quality up to opts.JPEGQuality that fits. write must encode with *opts.
+func fitOutput(write func(w io.Writer) error, opts *recolor.EncodeOptions, format recolor.Format, maxSize int64) ([]byte, error) {
+	encode := func() ([]byte, error) {
+		var buf bytes.Buffer
+		err := write(&buf)
+		return buf.Bytes(), err
+	}
+
+	best, err := encode()
+	if err != nil || int64(len(best)) <= maxSize {
+		return best, err
+	}
+	if format != recolor.FormatJPEG {
+		return nil, fmt.Errorf("%s output is %s, over the limit of %s. JPEG output can be shrunk to fit",
+			format, formatByteSize(int64(len(best))), formatByteSize(maxSize))
+	}
+
+	if opts.JPEGQuality == 0 {
+		opts.JPEGQuality = recolor.DefaultJPEGQuality
+	}
+	// Quality hi is known not to fit
+	lo, hi := 1, opts.JPEGQuality
+	best = nil
+	for lo < hi {
+		opts.JPEGQuality = (lo + hi) / 2
+		data, err := encode()
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(data)) <= maxSize {
+			best, lo = data, opts.JPEGQuality+1
+		} else {
+			hi = opts.JPEGQuality
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("output does not fit in %s even at JPEG quality 1", formatByteSize(maxSize))
+	}
+	opts.JPEGQuality = lo - 1
+	return best, nil
+}
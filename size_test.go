@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ashish0kumar/tint/recolor"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"500", 500},
+		{"1", 1},
+		{"500B", 500},
+		{"1.5K", 1536},
+		{"0.5kb", 512},
+		{"2mb", 2 << 20},
+		{" 1 GiB ", 1 << 30},
+		{"1.9", 1},
+	}
+	for _, tt := range tests {
+		if got, err := parseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseByteSize('%s') = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "KB", "abc", "5TB", "0", "-1", "-2MB", "0.1B", "0.5", "NaN", "inf", "1e30", "1e10G"} {
+		if got, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize('%s') = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFitOutput(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	encoded := func(format recolor.Format, opts recolor.EncodeOptions) []byte {
+		var b bytes.Buffer
+		if err := recolor.EncodeWithOptions(&b, img, format, opts); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	fit := func(opts *recolor.EncodeOptions, format recolor.Format, maxSize int64) ([]byte, error) {
+		write := func(w io.Writer) error { return recolor.EncodeWithOptions(w, img, format, *opts) }
+		return fitOutput(write, opts, format, maxSize)
+	}
+
+	// Output that fits is returned at the requested quality
+	opts := recolor.EncodeOptions{JPEGQuality: 90}
+	data, err := fit(&opts, recolor.FormatJPEG, 1<<30)
+	if err != nil || opts.JPEGQuality != 90 || !bytes.Equal(data, encoded(recolor.FormatJPEG, opts)) {
+		t.Errorf("fitting output: quality %d, %v", opts.JPEGQuality, err)
+	}
+
+	// Larger output is encoded at the highest quality that fits, starting
+	// from the default quality when none is set
+	limit := int64(len(encoded(recolor.FormatJPEG, recolor.EncodeOptions{JPEGQuality: 50})))
+	for _, quality := range []int{90, 0} {
+		opts := recolor.EncodeOptions{JPEGQuality: quality}
+		data, err := fit(&opts, recolor.FormatJPEG, limit)
+		if err != nil {
+			t.Fatalf("quality %d: %v", quality, err)
+		}
+		if int64(len(data)) > limit || opts.JPEGQuality < 50 || opts.JPEGQuality >= max(quality, recolor.DefaultJPEGQuality) {
+			t.Errorf("quality %d: lowered to %d, %d bytes for a limit of %d", quality, opts.JPEGQuality, len(data), limit)
+		}
+		if !bytes.Equal(data, encoded(recolor.FormatJPEG, opts)) {
+			t.Errorf("quality %d: data does not match quality %d", quality, opts.JPEGQuality)
+		}
+	}
+
+	// Output that cannot shrink enough is an error
+	if _, err := fit(&recolor.EncodeOptions{JPEGQuality: 90}, recolor.FormatJPEG, 100); err == nil || !strings.Contains(err.Error(), "quality 1") {
+		t.Errorf("tiny limit: error = %v", err)
+	}
+	if _, err := fit(&recolor.EncodeOptions{}, recolor.FormatPNG, 100); err == nil || !strings.Contains(err.Error(), "over the limit") {
+		t.Errorf("PNG over the limit: error = %v", err)
+	}
+
+	failure := errors.New("write failed")
+	write := func(w io.Writer) error { return failure }
+	if _, err := fitOutput(write, &recolor.EncodeOptions{}, recolor.FormatJPEG, 1); !errors.Is(err, failure) {
+		t.Errorf("write error = %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,144 @@
+package themes
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// builtinColorErrors collects errors from hexToRGBA while the built-in themes are initialized
+// They are reported by ValidateThemeData instead of stopping the program
+var builtinColorErrors []error
+
+// hexToRGBA converts a color string for the built-in theme data to a color.RGBA
+// Invalid strings yield transparent black and are reported by ValidateThemeData
+func hexToRGBA(hex string) color.RGBA {
+	c, err := ParseHex(hex)
+	if err != nil {
+		builtinColorErrors = append(builtinColorErrors, err)
+	}
+	return c
+}
+
+// ParseHex parses a color string into a color.RGBA
+// It accepts "#RGB", "#RGBA", "#RRGGBB" and "#RRGGBBAA" hexadecimal notation, and
+// "rgb(r, g, b)" or "rgba(r, g, b, a)" with channels from 0 to 255 and alpha from 0 to 1.
+// Colors with an alpha below 255 are premultiplied, as color.RGBA requires.
+func ParseHex(s string) (color.RGBA, error) {
+	cleaned := strings.ToLower(strings.TrimSpace(s))
+
+	switch {
+	case strings.HasPrefix(cleaned, "#"):
+		return parseHexNotation(s, cleaned[1:])
+	case strings.HasPrefix(cleaned, "rgb"):
+		return parseRGBFunction(s, cleaned)
+	default:
+		return color.RGBA{}, fmt.Errorf("invalid color '%s': expected #RGB, #RGBA, #RRGGBB, #RRGGBBAA or rgb(r, g, b)", s)
+	}
+}
+
+// parseHexNotation parses the digits of a hexadecimal color without the leading '#'
+func parseHexNotation(original, hex string) (color.RGBA, error) {
+	var digits int
+	switch len(hex) {
+	case 3, 4:
+		digits = 1
+	case 6, 8:
+		digits = 2
+	default:
+		return color.RGBA{}, fmt.Errorf("invalid color '%s': hex colors must have 3, 4, 6 or 8 digits, got %d", original, len(hex))
+	}
+
+	channelNames := []string{"red", "green", "blue", "alpha"}
+	channels := [4]uint8{0, 0, 0, 0xFF}
+	for i := 0; i*digits < len(hex); i++ {
+		segment := hex[i*digits : (i+1)*digits]
+		if digits == 1 {
+			segment += segment // Short form, e.g. "f" means "ff"
+		}
+		val, err := parseHexChannel(segment)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color '%s': bad %s channel '%s'", original, channelNames[i], hex[i*digits:(i+1)*digits])
+		}
+		channels[i] = val
+	}
+
+	return premultiply(channels[0], channels[1], channels[2], channels[3]), nil
+}
+
+// parseHexChannel parses a two-character hexadecimal string into a uint8 value
+func parseHexChannel(s string) (uint8, error) {
+	val, err := strconv.ParseUint(s, 16, 8)
+	if err != nil {
+		return 0, err
+	}
+	return uint8(val), nil
+}
+
+// parseRGBFunction parses "rgb(r, g, b)" and "rgba(r, g, b, a)"
+func parseRGBFunction(original, cleaned string) (color.RGBA, error) {
+	open := strings.IndexByte(cleaned, '(')
+	if open < 0 || !strings.HasSuffix(cleaned, ")") {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s': expected rgb(r, g, b) or rgba(r, g, b, a)", original)
+	}
+	name := strings.TrimSpace(cleaned[:open])
+	if name != "rgb" && name != "rgba" {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s': unknown function '%s'", original, name)
+	}
+
+	args := strings.FieldsFunc(cleaned[open+1:len(cleaned)-1], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '/'
+	})
+	if len(args) != 3 && len(args) != 4 {
+		return color.RGBA{}, fmt.Errorf("invalid color '%s': expected 3 or 4 values, got %d", original, len(args))
+	}
+
+	channelNames := []string{"red", "green", "blue"}
+	var channels [3]uint8
+	for i := range channels {
+		val, err := strconv.ParseUint(args[i], 10, 8)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color '%s': %s channel must be an integer from 0 to 255, got '%s'", original, channelNames[i], args[i])
+		}
+		channels[i] = uint8(val)
+	}
+
+	alpha := uint8(0xFF)
+	if len(args) == 4 {
+		val, err := strconv.ParseFloat(args[3], 64)
+		if err != nil || val < 0 || val > 1 {
+			return color.RGBA{}, fmt.Errorf("invalid color '%s': alpha must be a number from 0 to 1, got '%s'", original, args[3])
+		}
+		alpha = uint8(math.Round(val * 255))
+	}
+
+	return premultiply(channels[0], channels[1], channels[2], alpha), nil
+}
+
+// premultiply converts non-premultiplied channels to a color.RGBA
+func premultiply(r, g, b, a uint8) color.RGBA {
+	return color.RGBAModel.Convert(color.NRGBA{R: r, G: g, B: b, A: a}).(color.RGBA)
+}
+
+// ParsePalette parses a map of color names to color strings, as accepted by ParseHex
+// The error names the first invalid color in alphabetical order
+func ParsePalette(colors map[string]string) (map[string]color.RGBA, error) {
+	names := make([]string, 0, len(colors))
+	for name := range colors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	palette := make(map[string]color.RGBA, len(colors))
+	for _, name := range names {
+		c, err := ParseHex(colors[name])
+		if err != nil {
+			return nil, fmt.Errorf("color '%s': %w", name, err)
+		}
+		palette[name] = c
+	}
+	return palette, nil
+}
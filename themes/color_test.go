@@ -0,0 +1,49 @@
+package themes
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestParseHex(t *testing.T) {
+	tests := []struct {
+		in   string
+		want color.RGBA
+	}{
+		{"#f80", color.RGBA{0xff, 0x88, 0x00, 0xff}},
+		{" #2E3440 ", color.RGBA{0x2e, 0x34, 0x40, 0xff}},
+		{"#ff880080", color.RGBA{0x80, 0x44, 0x00, 0x80}},
+		{"rgb(46, 52, 64)", color.RGBA{46, 52, 64, 0xff}},
+		{"rgba(255, 0, 0, 0.5)", color.RGBA{0x80, 0, 0, 0x80}},
+	}
+	for _, tt := range tests {
+		if got, err := ParseHex(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseHex('%s') = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseHexInvalid(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{"2e3440", "expected #RGB"},
+		{"#12345", "3, 4, 6 or 8 digits"},
+		{"#00zz00", "bad green channel 'zz'"},
+		{"rgb(256, 0, 0)", "red channel must be an integer"},
+		{"rgba(0, 0, 0, 2)", "alpha must be a number from 0 to 1"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseHex(tt.in); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ParseHex('%s') error = %v, want it to contain '%s'", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBuiltinThemes(t *testing.T) {
+	if err := ValidateThemeData(); err != nil {
+		t.Fatal(err)
+	}
+}
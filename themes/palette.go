@@ -0,0 +1,96 @@
+package themes
+
+import (
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// Variant tells whether a palette is meant for a light or a dark background
+type Variant string
+
+const (
+	VariantDark  Variant = "dark"
+	VariantLight Variant = "light"
+)
+
+// NamedColor is a palette color together with its role name, e.g. "base" or "red"
+type NamedColor struct {
+	Name  string
+	Color color.RGBA
+}
+
+// Palette is one flavor of a theme together with its metadata
+type Palette struct {
+	Name    string  // Theme name, e.g. "catppuccin"
+	Flavor  string  // Flavor name, "default" when the theme was selected without one
+	Variant Variant // Light or dark, empty when neither applies
+	Author  string  // Author of the original theme
+	Source  string  // URL of the original theme
+
+	// NamedColors holds the colors ordered by name, so the order is the same on every run
+	NamedColors []NamedColor
+}
+
+// newPalette builds a Palette from a map of color names to colors
+func newPalette(name, flavor string, colors map[string]color.RGBA) Palette {
+	named := make([]NamedColor, 0, len(colors))
+	for colorName, c := range colors {
+		named = append(named, NamedColor{Name: colorName, Color: c})
+	}
+	sort.Slice(named, func(i, j int) bool {
+		return named[i].Name < named[j].Name
+	})
+
+	return Palette{Name: name, Flavor: flavor, NamedColors: named}
+}
+
+// Key returns the "theme-flavor" name of the palette, or just the theme name for the default flavor
+func (p Palette) Key() string {
+	if p.Flavor == "" || p.Flavor == "default" {
+		return p.Name
+	}
+	return p.Name + "-" + p.Flavor
+}
+
+// Len returns the number of colors in the palette
+func (p Palette) Len() int {
+	return len(p.NamedColors)
+}
+
+// Colors returns the palette colors in order as a []color.Color
+func (p Palette) Colors() []color.Color {
+	colors := make([]color.Color, len(p.NamedColors))
+	for i, nc := range p.NamedColors {
+		colors[i] = nc.Color
+	}
+	return colors
+}
+
+// ColorPalette returns the palette colors in order as a color.Palette
+// color.Palette.Convert snaps a color to the nearest palette entry, without Shepard blending
+func (p Palette) ColorPalette() color.Palette {
+	return color.Palette(p.Colors())
+}
+
+// Lookup returns the color with the given role name, e.g. "base"
+// Names are matched exactly first, then without regard to case
+func (p Palette) Lookup(name string) (color.RGBA, bool) {
+	for _, nc := range p.NamedColors {
+		if nc.Name == name {
+			return nc.Color, true
+		}
+	}
+	for _, nc := range p.NamedColors {
+		if strings.EqualFold(nc.Name, name) {
+			return nc.Color, true
+		}
+	}
+	return color.RGBA{}, false
+}
+
+// clone returns a deep copy of the palette so callers cannot modify registered data
+func (p Palette) clone() Palette {
+	p.NamedColors = append([]NamedColor(nil), p.NamedColors...)
+	return p
+}
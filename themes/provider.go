@@ -0,0 +1,185 @@
+package themes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ProviderPrefix marks a theme name that is produced by an external program, e.g. "exec:./palette.sh"
+const ProviderPrefix = "exec:"
+
+// ProviderProtocolVersion is sent with every request so providers can detect future changes
+const ProviderProtocolVersion = 1
+
+// Limits applied to provider programs
+const (
+	DefaultProviderTimeout = 30 * time.Second
+	maxProviderOutput      = 1 << 20 // 1 MiB of palette JSON is far more than 256 colors need
+)
+
+// providerNamePattern matches the theme and flavor names a provider may return
+// They end up in output file names, so they follow the built-in theme names and
+// cannot carry path separators or dots
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ImageStats describes the image being recolored, so a provider can tailor its palette
+type ImageStats struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Format    string  `json:"format,omitempty"`
+	Average   string  `json:"average,omitempty"`   // Mean color as "#rrggbb"
+	Luminance float64 `json:"luminance,omitempty"` // Mean Rec. 709 luma, from 0 to 1
+}
+
+// ProviderRequest is the JSON document written to a provider's stdin
+type ProviderRequest struct {
+	Version int         `json:"version"`
+	Theme   string      `json:"theme,omitempty"` // First argument after the command, if any
+	Image   *ImageStats `json:"image,omitempty"`
+}
+
+// ProviderResponse is the JSON document a provider writes to stdout
+// Colors maps color names to any notation accepted by ParseHex
+type ProviderResponse struct {
+	Name    string            `json:"name"`
+	Flavor  string            `json:"flavor,omitempty"`
+	Variant Variant           `json:"variant,omitempty"`
+	Author  string            `json:"author,omitempty"`
+	Source  string            `json:"source,omitempty"`
+	Colors  map[string]string `json:"colors"`
+}
+
+// IsProvider reports whether a theme name refers to an external provider program
+func IsProvider(themeAndFlavor string) bool {
+	return strings.HasPrefix(strings.TrimSpace(themeAndFlavor), ProviderPrefix)
+}
+
+// RunProvider runs the provider named by an "exec:" theme and returns the palette it prints
+// The part after the prefix is split on spaces into the program and its arguments; no
+// shell is involved. The request is written to the program's stdin as JSON, and the
+// response on stdout is checked with the same rules as the built-in palettes.
+// If ctx has no deadline, DefaultProviderTimeout applies.
+func RunProvider(ctx context.Context, provider string, stats *ImageStats) (Palette, error) {
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(provider), ProviderPrefix))
+	if len(fields) == 0 {
+		return Palette{}, &themeError{fmt.Sprintf("palette provider '%s' does not name a program", provider)}
+	}
+
+	req := ProviderRequest{Version: ProviderProtocolVersion, Image: stats}
+	if len(fields) > 1 {
+		req.Theme = fields[1]
+	}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return Palette{}, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultProviderTimeout)
+		defer cancel()
+	}
+
+	var stdout limitedBuffer
+	var stderr limitedBuffer
+	stdout.limit, stderr.limit = maxProviderOutput, 4096
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return Palette{}, fmt.Errorf("palette provider '%s' did not finish: %w", fields[0], ctxErr)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return Palette{}, fmt.Errorf("palette provider '%s' failed: %v: %s", fields[0], err, msg)
+		}
+		return Palette{}, fmt.Errorf("palette provider '%s' failed: %v", fields[0], err)
+	}
+	if stdout.truncated {
+		return Palette{}, fmt.Errorf("palette provider '%s' wrote more than %d bytes", fields[0], maxProviderOutput)
+	}
+
+	palette, err := ParseProviderResponse(stdout.Bytes())
+	if err != nil {
+		return Palette{}, fmt.Errorf("palette provider '%s': %w", fields[0], err)
+	}
+	return palette, nil
+}
+
+// ParseProviderResponse decodes and validates the JSON printed by a palette provider
+func ParseProviderResponse(data []byte) (Palette, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var resp ProviderResponse
+	if err := dec.Decode(&resp); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Palette{}, fmt.Errorf("no palette in the output")
+		}
+		return Palette{}, fmt.Errorf("invalid palette JSON: %v", err)
+	}
+	if dec.More() {
+		return Palette{}, fmt.Errorf("invalid palette JSON: unexpected data after the palette object")
+	}
+
+	switch resp.Variant {
+	case "", VariantDark, VariantLight:
+	default:
+		return Palette{}, fmt.Errorf("invalid variant '%s', use '%s' or '%s'", resp.Variant, VariantDark, VariantLight)
+	}
+
+	colors, err := ParsePalette(resp.Colors)
+	if err != nil {
+		return Palette{}, err
+	}
+
+	name := normalizeName(resp.Name)
+	if name == "" {
+		name = "custom"
+	}
+	flavor := normalizeName(resp.Flavor)
+	if flavor == "" {
+		flavor = "default"
+	}
+	for _, n := range []string{name, flavor} {
+		if !providerNamePattern.MatchString(n) {
+			return Palette{}, fmt.Errorf("invalid palette name '%s', use only ASCII letters, digits and '_'", n)
+		}
+	}
+
+	p := newPalette(name, flavor, colors)
+	p.Variant = resp.Variant
+	p.Author = resp.Author
+	p.Source = resp.Source
+	if err := validatePalette(p.Key(), p.NamedColors); err != nil {
+		return Palette{}, err
+	}
+	return p, nil
+}
+
+// limitedBuffer keeps at most limit bytes and records whether more were written
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,42 @@
+package themes
+
+import (
+	"strings"
+	"testing"
+)
+
+// providerJSON returns a provider response with the given name and flavor
+func providerJSON(name, flavor string) []byte {
+	return []byte(`{"name": "` + name + `", "flavor": "` + flavor + `", "colors": {"bg": "#000", "fg": "#fff", "red": "#f00"}}`)
+}
+
+func TestParseProviderResponseNames(t *testing.T) {
+	tests := []struct {
+		name, flavor string
+		wantKey      string
+	}{
+		{"", "", "custom"},
+		{"Sunset", "Dusk", "sunset-dusk"},
+		{" wal_2 ", "v10", "wal_2-v10"},
+	}
+	for _, tt := range tests {
+		p, err := ParseProviderResponse(providerJSON(tt.name, tt.flavor))
+		if err != nil {
+			t.Errorf("name %q, flavor %q: %v", tt.name, tt.flavor, err)
+			continue
+		}
+		if p.Key() != tt.wantKey {
+			t.Errorf("name %q, flavor %q: key = %s, want %s", tt.name, tt.flavor, p.Key(), tt.wantKey)
+		}
+	}
+
+	// Names become part of output file names, so anything that could leave the
+	// output directory or split the key is rejected
+	for _, bad := range []string{"../../x", "a/b", `a\\b`, "..", ".hidden", "a-b", "a b", "a.png", "é"} {
+		for _, resp := range [][]byte{providerJSON(bad, ""), providerJSON("wal", bad)} {
+			if _, err := ParseProviderResponse(resp); err == nil || !strings.Contains(err.Error(), "invalid palette name") {
+				t.Errorf("%s: error = %v, want an invalid name error", resp, err)
+			}
+		}
+	}
+}
@@ -1,101 +1,191 @@
 package themes
 
 import (
+	"errors"
 	"fmt"
 	"image/color"
-	"log"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 )
 
-var logInitOnce sync.Once
+// validatePalette checks that a palette contains a usable number of uniquely named colors
+func validatePalette(paletteName string, colors []NamedColor) error {
+	if len(colors) == 0 {
+		return fmt.Errorf("palette '%s' is empty", paletteName)
+	}
+	if len(colors) < 3 {
+		return fmt.Errorf("palette '%s' has too few colors (%d), need at least 3", paletteName, len(colors))
+	}
+	if len(colors) > 256 {
+		return fmt.Errorf("palette '%s' has too many colors (%d), maximum is 256", paletteName, len(colors))
+	}
+
+	seen := make(map[string]bool, len(colors))
+	for _, nc := range colors {
+		if nc.Name == "" {
+			return fmt.Errorf("palette '%s' has a color without a name", paletteName)
+		}
+		if seen[nc.Name] {
+			return fmt.Errorf("palette '%s' has more than one color named '%s'", paletteName, nc.Name)
+		}
+		seen[nc.Name] = true
+	}
+	return nil
+}
 
-func setLogFlags() {
-	log.SetFlags(0) // Simpler logging output
+// ErrInvalidTheme is matched by errors.Is for unknown theme and flavor names
+var ErrInvalidTheme = errors.New("invalid theme")
+
+// themeError describes an unknown theme or flavor and matches ErrInvalidTheme
+type themeError struct {
+	msg string
 }
 
-// hexToRGBA converts a hexadecimal color string ("#RRGGBB" or "#RRGGBBAA") to a color.RGBA
-func hexToRGBA(hex string) color.RGBA {
-	logInitOnce.Do(setLogFlags)
+func (e *themeError) Error() string { return e.msg }
 
-	if len(hex) < 7 || hex[0] != '#' {
-		log.Fatalf("invalid hex color string: %s", hex)
-	}
+func (e *themeError) Is(target error) bool { return target == ErrInvalidTheme }
 
-	hex = hex[1:] // Remove '#'
+// builtinTheme describes a theme shipped with tint
+type builtinTheme struct {
+	flavors      map[string]map[string]color.RGBA
+	author       string
+	source       string
+	variant      Variant  // Variant of every flavor not listed in lightFlavors
+	lightFlavors []string // Flavors meant for a light background
+}
 
-	if len(hex) != 6 && len(hex) != 8 {
-		log.Fatalf("invalid hex color string length: %s", hex)
-	}
+// builtinThemes lists the themes shipped with tint, keyed by theme name
+var builtinThemes = map[string]builtinTheme{
+	"catppuccin": {Catppuccin, "Catppuccin", "https://github.com/catppuccin/catppuccin", VariantDark, []string{"latte"}},
+	"rosepine":   {RosePine, "Rosé Pine", "https://rosepinetheme.com", VariantDark, []string{"dawn"}},
+	"nord":       {Nord, "Arctic Ice Studio", "https://www.nordtheme.com", VariantDark, nil},
+	"tokyonight": {TokyoNight, "Folke Lemaitre", "https://github.com/folke/tokyonight.nvim", VariantDark, []string{"light"}},
+	"gruvbox":    {Gruvbox, "Pavel Pertsev", "https://github.com/morhetz/gruvbox", VariantDark, []string{"light"}},
+	"everforest": {Everforest, "sainnhe", "https://github.com/sainnhe/everforest", VariantDark, []string{"light"}},
+	"dracula":    {Dracula, "Zeno Rocha", "https://draculatheme.com", VariantDark, nil},
+	"solarized":  {Solarized, "Ethan Schoonover", "https://ethanschoonover.com/solarized", VariantDark, []string{"light"}},
+	"monochrome": {Monochrome, "tint", "https://github.com/ashish0kumar/tint", "", nil},
+	"kanagawa":   {Kanagawa, "Tommaso Laurenzi", "https://github.com/rebelot/kanagawa.nvim", VariantDark, []string{"lotus"}},
+	"ayu":        {Ayu, "Ike Ku", "https://github.com/ayu-theme/ayu-colors", VariantDark, []string{"light"}},
+	"monokaipro": {MonokaiPro, "Monokai", "https://monokai.pro", VariantDark, nil},
+	"nightowl":   {NightOwl, "Sarah Drasner", "https://github.com/sdras/night-owl-vscode-theme", VariantDark, nil},
+}
+
+// Registry is a set of themes that is safe for concurrent use
+// Each theme has one or more flavors; the "default" flavor is used when
+// a lookup names only the theme
+type Registry struct {
+	mu     sync.RWMutex
+	themes map[string]map[string]Palette
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{themes: make(map[string]map[string]Palette)}
+}
 
-	parseChannel := func(segment, name string) uint8 {
-		val, err := parseHexChannel(segment)
-		if err != nil {
-			log.Fatalf("invalid hex color (%s channel): %s", name, hex)
+// NewBuiltinRegistry creates a registry holding the themes shipped with tint
+func NewBuiltinRegistry() *Registry {
+	r := NewRegistry()
+	for themeName, theme := range builtinThemes {
+		flavors := make(map[string]Palette, len(theme.flavors))
+		for flavorName, colors := range theme.flavors {
+			p := newPalette(themeName, flavorName, colors)
+			p.Author = theme.author
+			p.Source = theme.source
+			p.Variant = theme.variant
+			for _, light := range theme.lightFlavors {
+				if flavorName == light {
+					p.Variant = VariantLight
+				}
+			}
+			flavors[flavorName] = p
 		}
-		return val
+		r.themes[themeName] = flavors
 	}
+	return r
+}
 
-	r := parseChannel(hex[0:2], "red")
-	g := parseChannel(hex[2:4], "green")
-	b := parseChannel(hex[4:6], "blue")
-	a := uint8(0xFF)
-	if len(hex) == 8 {
-		a = parseChannel(hex[6:8], "alpha")
-	}
+// DefaultRegistry holds the built-in themes and backs GetPalette and the other package-level functions
+var DefaultRegistry = NewBuiltinRegistry()
 
-	return color.RGBA{R: r, G: g, B: b, A: a}
+// normalizeName lowercases and trims a theme or flavor name
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
 }
 
-// parseHexChannel parses a two-character hexadecimal string into a uint8 value
-func parseHexChannel(s string) (uint8, error) {
-	val, err := strconv.ParseUint(s, 16, 8)
-	if err != nil {
-		return 0, err
-	}
-	return uint8(val), nil
+// Register adds a flavor of a theme from a map of color names to colors
+// It replaces any existing flavor with the same name. An empty flavor registers the
+// theme's "default" flavor. Theme names must not contain '-', which separates the
+// theme from the flavor in lookups.
+func (r *Registry) Register(themeName, flavorName string, colors map[string]color.RGBA) error {
+	return r.RegisterPalette(newPalette(themeName, flavorName, colors))
 }
 
-// validatePalette checks that a palette map contains valid colors
-func validatePalette(paletteName string, palette map[string]color.RGBA) error {
-	if len(palette) == 0 {
-		return fmt.Errorf("palette '%s' is empty", paletteName)
+// RegisterPalette adds a palette under its Name and Flavor, keeping its metadata and color order
+// It follows the same rules as Register
+func (r *Registry) RegisterPalette(p Palette) error {
+	p = p.clone()
+	p.Name = normalizeName(p.Name)
+	p.Flavor = normalizeName(p.Flavor)
+	if p.Flavor == "" {
+		p.Flavor = "default"
 	}
-	if len(palette) < 3 {
-		return fmt.Errorf("palette '%s' has too few colors (%d), need at least 3", paletteName, len(palette))
+
+	if p.Name == "" {
+		return fmt.Errorf("theme name cannot be empty")
+	}
+	if strings.Contains(p.Name, "-") {
+		return fmt.Errorf("theme name '%s' must not contain '-'", p.Name)
 	}
-	if len(palette) > 256 {
-		return fmt.Errorf("palette '%s' has too many colors (%d), maximum is 256", paletteName, len(palette))
+	if err := validatePalette(p.Key(), p.NamedColors); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.themes[p.Name] == nil {
+		r.themes[p.Name] = make(map[string]Palette)
 	}
+	r.themes[p.Name][p.Flavor] = p
 	return nil
 }
 
-// AllThemeData holds all available themes
-// The key is the theme name and value is a map of flavors to their color palettes
-var AllThemeData = map[string]map[string]map[string]color.RGBA{
-	"catppuccin": Catppuccin,
-	"rosepine":   RosePine,
-	"nord":       Nord,
-	"tokyonight": TokyoNight,
-	"gruvbox":    Gruvbox,
-	"everforest": Everforest,
-	"dracula":    Dracula,
-	"solarized":  Solarized,
-	"monochrome": Monochrome,
-	"kanagawa":   Kanagawa,
-	"ayu":        Ayu,
-	"monokaipro": MonokaiPro,
-	"nightowl":   NightOwl,
-}
-
-// GetPalette retrieves a palette by theme name and optional flavor
+// Unregister removes a flavor of a theme, or the whole theme if flavorName is empty
+// It reports whether anything was removed
+func (r *Registry) Unregister(themeName, flavorName string) bool {
+	themeName = normalizeName(themeName)
+	flavorName = normalizeName(flavorName)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	themeMap, ok := r.themes[themeName]
+	if !ok {
+		return false
+	}
+	if flavorName == "" {
+		delete(r.themes, themeName)
+		return true
+	}
+	if _, ok := themeMap[flavorName]; !ok {
+		return false
+	}
+	delete(themeMap, flavorName)
+	if len(themeMap) == 0 {
+		delete(r.themes, themeName)
+	}
+	return true
+}
+
+// Lookup retrieves a palette by theme name and optional flavor
 // Format: "theme-flavor" (e.g., "catppuccin-mocha")
-func GetPalette(themeAndFlavor string) ([]color.Color, error) {
-	cleaned := strings.ToLower(strings.TrimSpace(themeAndFlavor))
+func (r *Registry) Lookup(themeAndFlavor string) (Palette, error) {
+	cleaned := normalizeName(themeAndFlavor)
 	if cleaned == "" {
-		return nil, fmt.Errorf("theme name cannot be empty")
+		return Palette{}, &themeError{"theme name cannot be empty"}
 	}
 
 	parts := strings.SplitN(cleaned, "-", 2)
@@ -105,63 +195,70 @@ func GetPalette(themeAndFlavor string) ([]color.Color, error) {
 		subFlavor = parts[1]
 	}
 
-	themeMap, ok := AllThemeData[themeName]
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	themeMap, ok := r.themes[themeName]
 	if !ok {
-		return nil, fmt.Errorf("invalid theme '%s'. Available themes: %s",
-		themeName, strings.Join(GetAvailableThemeNames(), ", "))
+		return Palette{}, &themeError{fmt.Sprintf("invalid theme '%s'. Available themes: %s",
+			themeName, strings.Join(r.themeNames(), ", "))}
 	}
 
-	var selectedPaletteMap map[string]color.RGBA
+	var selected Palette
 	if subFlavor != "" {
 		if subPalette, ok := themeMap[subFlavor]; ok {
-			selectedPaletteMap = subPalette
+			selected = subPalette
 		} else {
-			availableFlavors := GetAvailableFlavorNames(themeName)
+			availableFlavors := r.flavorNames(themeName)
 			if len(availableFlavors) == 0 {
-				return nil, fmt.Errorf("theme '%s' does not have flavors, use just '%s'", themeName, themeName)
+				return Palette{}, &themeError{fmt.Sprintf("theme '%s' does not have flavors, use just '%s'", themeName, themeName)}
 			}
-			return nil, fmt.Errorf("invalid flavor '%s' for theme '%s'. Available flavors: %s",
-			subFlavor, themeName, strings.Join(availableFlavors, ", "))
+			return Palette{}, &themeError{fmt.Sprintf("invalid flavor '%s' for theme '%s'. Available flavors: %s",
+				subFlavor, themeName, strings.Join(availableFlavors, ", "))}
 		}
 	} else {
 		if defaultPalette, ok := themeMap["default"]; ok {
-			selectedPaletteMap = defaultPalette
+			selected = defaultPalette
 		} else {
-			return nil, fmt.Errorf("theme '%s' has no defined palettes", themeName)
+			return Palette{}, &themeError{fmt.Sprintf("theme '%s' has no default flavor. Available flavors: %s",
+				themeName, strings.Join(r.flavorNames(themeName), ", "))}
 		}
 	}
 
-	paletteKey := themeName
-	if subFlavor != "" {
-		paletteKey = fmt.Sprintf("%s-%s", themeName, subFlavor)
+	if err := validatePalette(selected.Key(), selected.NamedColors); err != nil {
+		return Palette{}, fmt.Errorf("invalid palette for %s: %v", selected.Key(), err)
 	}
 
-	if err := validatePalette(paletteKey, selectedPaletteMap); err != nil {
-		return nil, fmt.Errorf("invalid palette for %s: %v", paletteKey, err)
-	}
+	return selected.clone(), nil
+}
 
-	// Convert to []color.Color
-	paletteColors := make([]color.Color, 0, len(selectedPaletteMap))
-	for _, c := range selectedPaletteMap {
-		paletteColors = append(paletteColors, c)
-	}
+// Themes returns a sorted slice of the registered theme names
+func (r *Registry) Themes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.themeNames()
+}
 
-	return paletteColors, nil
+// Flavors returns a sorted slice of the flavor names of a theme, excluding "default"
+func (r *Registry) Flavors(themeName string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.flavorNames(normalizeName(themeName))
 }
 
-// GetAvailableThemeNames returns a sorted slice of available theme names
-func GetAvailableThemeNames() []string {
-	names := make([]string, 0, len(AllThemeData))
-	for name := range AllThemeData {
+// themeNames returns the sorted theme names, the caller must hold r.mu
+func (r *Registry) themeNames() []string {
+	names := make([]string, 0, len(r.themes))
+	for name := range r.themes {
 		names = append(names, name)
 	}
 	sort.Strings(names)
 	return names
 }
 
-// GetAvailableFlavorNames returns a sorted slice of available flavor names for a given theme
-func GetAvailableFlavorNames(themeName string) []string {
-	themeMap, ok := AllThemeData[strings.ToLower(themeName)]
+// flavorNames returns the sorted flavor names of a theme, the caller must hold r.mu
+func (r *Registry) flavorNames(themeName string) []string {
+	themeMap, ok := r.themes[themeName]
 	if !ok {
 		return nil
 	}
@@ -176,23 +273,47 @@ func GetAvailableFlavorNames(themeName string) []string {
 	return names
 }
 
-// ValidateThemeData checks all theme data at startup
-func ValidateThemeData() error {
-	for themeName, themeMap := range AllThemeData {
+// Validate checks every palette in the registry
+func (r *Registry) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, themeName := range r.themeNames() {
+		themeMap := r.themes[themeName]
 		if len(themeMap) == 0 {
 			return fmt.Errorf("theme '%s' has no flavor definitions", themeName)
 		}
 
-		for flavorName, palette := range themeMap {
-			paletteKey := themeName
-			if flavorName != "default" {
-				paletteKey = fmt.Sprintf("%s-%s", themeName, flavorName)
-			}
-
-			if err := validatePalette(paletteKey, palette); err != nil {
+		for _, palette := range themeMap {
+			if err := validatePalette(palette.Key(), palette.NamedColors); err != nil {
 				return err
 			}
 		}
 	}
 	return nil
 }
+
+// GetPalette retrieves a palette from the default registry
+// Format: "theme-flavor" (e.g., "catppuccin-mocha")
+// Use Palette.Colors for the colors as a []color.Color
+func GetPalette(themeAndFlavor string) (Palette, error) {
+	return DefaultRegistry.Lookup(themeAndFlavor)
+}
+
+// GetAvailableThemeNames returns a sorted slice of theme names in the default registry
+func GetAvailableThemeNames() []string {
+	return DefaultRegistry.Themes()
+}
+
+// GetAvailableFlavorNames returns a sorted slice of flavor names for a theme in the default registry
+func GetAvailableFlavorNames(themeName string) []string {
+	return DefaultRegistry.Flavors(themeName)
+}
+
+// ValidateThemeData checks the built-in color definitions and all theme data in the default registry
+func ValidateThemeData() error {
+	if len(builtinColorErrors) > 0 {
+		return fmt.Errorf("invalid built-in theme colors: %w", errors.Join(builtinColorErrors...))
+	}
+	return DefaultRegistry.Validate()
+}
@@ -0,0 +1,64 @@
+package themes
+
+import (
+	"fmt"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+var testPalette = map[string]color.RGBA{
+	"base": {0x1e, 0x1e, 0x2e, 0xff},
+	"text": {0xcd, 0xd6, 0xf4, 0xff},
+	"blue": {0x89, 0xb4, 0xfa, 0xff},
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewBuiltinRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("theme%d", i)
+			for j := 0; j < 100; j++ {
+				if err := r.Register(name, "", testPalette); err != nil {
+					t.Error(err)
+					return
+				}
+				r.Unregister(name, "")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := r.Lookup("catppuccin-mocha"); err != nil {
+					t.Error(err)
+					return
+				}
+				r.Themes()
+				r.Flavors("catppuccin")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("Mine", "Dark", testPalette); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Lookup("mine-dark"); err != nil {
+		t.Errorf("Lookup: %v", err)
+	}
+	if err := r.Register("my-theme", "", testPalette); err == nil {
+		t.Error("Register accepted a theme name containing '-'")
+	}
+	if !r.Unregister("mine", "") {
+		t.Error("Unregister found no theme")
+	}
+	if _, err := r.Lookup("mine-dark"); err == nil {
+		t.Error("Lookup succeeded after Unregister")
+	}
+}